@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"query-counter/sketch"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// approxCounter summarizes the query stream in fixed memory.
+type approxCounter interface {
+	Add(key string, delta uint64)
+	Export(w *bufio.Writer)
+	Summary() string
+}
+
+// cmsCounter estimates counts with a Count-Min Sketch and keeps the keys of
+// the highest estimates.
+type cmsCounter struct {
+	sketch  *sketch.CountMin
+	top     *sketch.HeavyHitters
+	epsilon float64
+}
+
+func (c *cmsCounter) Add(key string, delta uint64) {
+	c.top.Offer(key, c.sketch.Add(key, delta))
+}
+
+// Export writes the final estimates, the kept ones may lag behind, so the
+// keys are sorted again by the written estimates.
+func (c *cmsCounter) Export(w *bufio.Writer) {
+	items := c.top.Items()
+	for i := range items {
+		items[i].Count = c.sketch.Estimate(items[i].Key)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	for _, item := range items {
+		w.WriteString(item.Key)
+		w.WriteByte('\t')
+		w.WriteString(strconv.FormatUint(item.Count, 10))
+		w.WriteByte('\n')
+	}
+}
+
+func (c *cmsCounter) Summary() string {
+	total := c.sketch.Total()
+	return fmt.Sprintf("count-min sketch: %d bytes, total %d, overcount bound %.0f",
+		c.sketch.MemorySize(), total, c.epsilon*float64(total))
+}
+
+// spaceSavingCounter exports every monitored key with its count and maximum
+// overcount.
+type spaceSavingCounter struct {
+	*sketch.SpaceSaving
+	k int
+}
+
+func (c *spaceSavingCounter) Export(w *bufio.Writer) {
+	for _, counter := range c.Counters() {
+		w.WriteString(counter.Key)
+		w.WriteByte('\t')
+		w.WriteString(strconv.FormatUint(counter.Count, 10))
+		w.WriteByte('\t')
+		w.WriteString(strconv.FormatUint(counter.Error, 10))
+		w.WriteByte('\n')
+	}
+}
+
+func (c *spaceSavingCounter) Summary() string {
+	total := c.Total()
+	return fmt.Sprintf("space-saving: %d counters, total %d, keys above %d are guaranteed", c.k, total, total/uint64(c.k))
+}
+
+// ApproxWorker counts queries in a fixed memory summary instead of the cache
+// and DB.
+type ApproxWorker struct {
+	counter  approxCounter
+	poolSize int
+	wg       *sync.WaitGroup
+	workers  chan query
+}
+
+func NewApproxWorker(counter approxCounter, poolSize int) *ApproxWorker {
+	var wg sync.WaitGroup
+
+	return &ApproxWorker{
+		counter:  counter,
+		poolSize: poolSize,
+		wg:       &wg,
+		workers:  make(chan query, 100),
+	}
+}
+
+func (aw *ApproxWorker) worker(jobs <-chan query) {
+	defer aw.wg.Done()
+	for j := range jobs {
+		aw.counter.Add(j.key, j.vale)
+	}
+}
+
+func (aw *ApproxWorker) InitWorkers() {
+	aw.wg.Add(aw.poolSize)
+	for w := 1; w <= aw.poolSize; w++ {
+		go aw.worker(aw.workers)
+	}
+}
+
+func (aw *ApproxWorker) Send(queries []query, offset int64) {
+	for _, q := range queries {
+		aw.workers <- q
+	}
+}
+
+func (aw *ApproxWorker) Close() {
+	close(aw.workers)
+}
+
+func (aw *ApproxWorker) Wait() {
+	aw.wg.Wait()
+}
+
+// ExportToFile writes the kept keys by descending count.
+func (aw *ApproxWorker) ExportToFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	aw.counter.Export(w)
+	return w.Flush()
+}
+
+func newApproxCounter(mode string, epsilon float64, delta float64, topK int) (approxCounter, error) {
+	if mode == modeTopK {
+		ss, err := sketch.NewSpaceSaving(topK)
+		if err != nil {
+			return nil, err
+		}
+		return &spaceSavingCounter{SpaceSaving: ss, k: topK}, nil
+	}
+	cms, err := sketch.NewCountMin(epsilon, delta)
+	if err != nil {
+		return nil, err
+	}
+	top, err := sketch.NewHeavyHitters(topK)
+	if err != nil {
+		return nil, err
+	}
+	return &cmsCounter{sketch: cms, top: top, epsilon: epsilon}, nil
+}
+
+func runApprox(counter approxCounter, inputPath string, columns []string, format *keyFormat, outputPath string) error {
+	worker := NewApproxWorker(counter, 10)
+
+	queryReader, err := NewQueryReader(inputPath, columns, format, worker)
+	if err != nil {
+		return err
+	}
+	defer queryReader.Close()
+
+	worker.InitWorkers()
+	if err := queryReader.Run(); err != nil {
+		return err
+	}
+	worker.Wait()
+	log.Println(counter.Summary())
+
+	return worker.ExportToFile(outputPath)
+}
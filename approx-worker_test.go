@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"query-counter/sketch"
+	"testing"
+)
+
+func TestCMSExportOrder(t *testing.T) {
+	cms, err := sketch.NewCountMin(0.001, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := sketch.NewHeavyHitters(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cmsCounter{sketch: cms, top: top, epsilon: 0.001}
+	c.Add("b", 5)
+	c.Add("a", 3)
+	c.Add("c", 4)
+	// the kept estimates of a and c lag behind the sketch
+	cms.Add("a", 10)
+	cms.Add("c", 1)
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	c.Export(w)
+	w.Flush()
+	if want := "a\t13\nb\t5\nc\t5\n"; buf.String() != want {
+		t.Errorf("exported\n%s\nwant\n%s", buf.String(), want)
+	}
+}
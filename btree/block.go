@@ -3,12 +3,8 @@ package btree
 import (
 	"encoding/binary"
 	"errors"
-	"os"
 )
 
-const blockSize = 8192
-const maxLeafSize = 60
-
 type bTreeBlock struct {
 	id               uint64   //8
 	currentLeafSize  uint64   //8
@@ -28,8 +24,60 @@ func (b *bTreeBlock) setChildren(childrenBlockIds []uint64) {
 }
 
 type bTreeBlockService struct {
-	file           *os.File
+	pager          pager
+	batch          *batchPager
 	lastBlockIndex uint64
+	blockSize      int64
+	maxLeafSize    int
+	prefixKeys     bool
+}
+
+func newBlockService(pager pager, h *header) (*bTreeBlockService, error) {
+	s := &bTreeBlockService{
+		pager:       pager,
+		blockSize:   int64(h.pageSize()),
+		maxLeafSize: int(h.maxLeafSize),
+		prefixKeys:  h.flags&flagPrefixKeys != 0,
+	}
+	blocks, err := pager.pageCount()
+	if err != nil {
+		return nil, err
+	}
+	if blocks > 0 {
+		s.lastBlockIndex = blocks - 1
+		if s.lastBlockIndex == 0 {
+			s.lastBlockIndex = 1
+		}
+	}
+	return s, nil
+}
+
+// nodeSize returns the number of bytes the node takes once written to a block.
+func (s *bTreeBlockService) nodeSize(node *bTreeNode) int {
+	size := blockHeaderSize + 8*len(node.childrenBlockIds)
+	if !s.prefixKeys {
+		return size + pairSize*len(node.elements)
+	}
+	prev := ""
+	for _, e := range node.elements {
+		size += e.prefixSize(prev)
+		prev = e.key
+	}
+	return size
+}
+
+// maxKeyLength returns the longest key the block format can hold. With
+// prefix compression a block must still fit three worst case keys, so that
+// both halves of a split node fit into their blocks.
+func (s *bTreeBlockService) maxKeyLength() int {
+	if !s.prefixKeys {
+		return maxKeyLength
+	}
+	length := (int(s.blockSize)-blockHeaderSize-4*8)/3 - prefixPairHeaderSize
+	if length > maxPrefixLength {
+		return maxPrefixLength
+	}
+	return length
 }
 
 func (s *bTreeBlockService) blockFromBuffer(bufferBlock []byte) *bTreeBlock {
@@ -47,17 +95,23 @@ func (s *bTreeBlockService) blockFromBuffer(bufferBlock []byte) *bTreeBlock {
 		blockOffset += 8
 	}
 	block.dataSet = make([]*pairs, block.currentLeafSize)
+	prev := ""
 	for i := 0; i < int(block.currentLeafSize); i++ {
 		p := EmptyPairs()
-		p.convertToPair(bufferBlock[blockOffset:])
+		if s.prefixKeys {
+			blockOffset += p.convertToPrefixPair(bufferBlock[blockOffset:], prev)
+			prev = p.key
+		} else {
+			p.convertToPair(bufferBlock[blockOffset:])
+			blockOffset += pairSize
+		}
 		block.dataSet[i] = p
-		blockOffset += pairSize
 	}
 	return block
 }
 
 func (s *bTreeBlockService) blockToBuffer(block *bTreeBlock) []byte {
-	bufferBlock := make([]byte, blockSize)
+	bufferBlock := make([]byte, s.blockSize)
 	blockOffset := 0
 	copy(bufferBlock[blockOffset:], uint64ToBytes(block.id))
 	blockOffset += 8
@@ -69,9 +123,15 @@ func (s *bTreeBlockService) blockToBuffer(block *bTreeBlock) []byte {
 		copy(bufferBlock[blockOffset:], uint64ToBytes(block.childrenBlockIds[i]))
 		blockOffset += 8
 	}
+	prev := ""
 	for i := 0; i < int(block.currentLeafSize); i++ {
-		copy(bufferBlock[blockOffset:], block.dataSet[i].convertToBytes())
-		blockOffset += pairSize
+		if s.prefixKeys {
+			blockOffset += copy(bufferBlock[blockOffset:], block.dataSet[i].convertToPrefixBytes(prev))
+			prev = block.dataSet[i].key
+		} else {
+			copy(bufferBlock[blockOffset:], block.dataSet[i].convertToBytes())
+			blockOffset += pairSize
+		}
 	}
 	return bufferBlock
 }
@@ -81,13 +141,8 @@ func (s *bTreeBlockService) blockByIndex(index int64) (*bTreeBlock, error) {
 		return nil, errors.New("index less 0")
 	}
 
-	offset := index * blockSize
-	if _, err := s.file.Seek(offset, 0); err != nil {
-		return nil, err
-	}
-
-	blockBuffer := make([]byte, blockSize)
-	if _, err := s.file.Read(blockBuffer); err != nil {
+	blockBuffer, err := s.pager.readPage(uint64(index))
+	if err != nil {
 		return nil, err
 	}
 
@@ -103,15 +158,7 @@ func (s *bTreeBlockService) rootBlock() (*bTreeBlock, error) {
 }
 
 func (s *bTreeBlockService) writeBlock(block *bTreeBlock) error {
-	seekOffset := blockSize * block.id
-	blockBuffer := s.blockToBuffer(block)
-	if _, err := s.file.Seek(int64(seekOffset), 0); err != nil {
-		return err
-	}
-	if _, err := s.file.Write(blockBuffer); err != nil {
-		return err
-	}
-	return nil
+	return s.pager.writePage(block.id, s.blockToBuffer(block))
 }
 
 func (s *bTreeBlockService) newBlock() (*bTreeBlock, error) {
@@ -1,24 +1,51 @@
 package btree
 
-import "os"
+import (
+	"errors"
+	"os"
+)
 
 type BTree struct {
-	root *bTreeNode
-	file *os.File
-	path string
+	root   *bTreeNode
+	file   *os.File
+	header *header
+	path   string
+	keep   bool
 }
 
-func NewBTree(path string) (*BTree, error) {
+func NewBTree(path string, config Config) (*BTree, error) {
 	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
-	ns := newBTreeNodeService(file)
+	// a crash in Commit leaves a complete journal to write again
+	journal, err := readJournal(path + journalSuffix)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if journal != nil {
+		if _, err := file.WriteAt(journal.header, 0); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	header, err := openHeader(file, config)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ns := newBTreeNodeService(file, header, config, journal)
 	rootNode, err := ns.getRootNode()
 	if err != nil {
 		return nil, err
 	}
-	return &BTree{root: rootNode, file: file, path: path}, nil
+	if journal != nil {
+		if err := os.Remove(path + journalSuffix); err != nil {
+			return nil, err
+		}
+	}
+	return &BTree{root: rootNode, file: file, header: header, path: path, keep: config.Keep}, nil
 }
 
 func (bt *BTree) Update(key string, value uint64) (bool, error) {
@@ -29,19 +56,43 @@ func (bt *BTree) Export(file *os.File) error {
 	return bt.root.writeToFile(file)
 }
 
+// StopAscend may be returned by the function passed to Ascend or AscendFrom
+// to stop the iteration without an error.
+var StopAscend = errors.New("stop ascend")
+
+// Ascend calls f for every pair in key order and stops at the first error.
+func (bt *BTree) Ascend(f func(key string, value uint64) error) error {
+	return bt.AscendFrom("", f)
+}
+
+// AscendFrom calls f for every pair with a key not less than start in key
+// order and stops at the first error.
+func (bt *BTree) AscendFrom(start string, f func(key string, value uint64) error) error {
+	if err := bt.root.ascend(start, f); err != StopAscend {
+		return err
+	}
+	return nil
+}
+
 func (bt *BTree) Insert(value *pairs) error {
+	if err := value.validate(bt.root.bs.maxKeyLength()); err != nil {
+		return err
+	}
 	return bt.root.insertPair(value, bt)
 }
 
+// MaxKeyLength returns the longest key Insert accepts.
+func (bt *BTree) MaxKeyLength() int {
+	return bt.root.bs.maxKeyLength()
+}
+
 func (bt *BTree) Get(key string) (uint64, bool, error) {
-	value, err := bt.root.getValue(key)
-	if err != nil {
-		return 0, false, err
-	}
-	if value == 0 {
-		return 0, false, nil
-	}
-	return value, true, nil
+	return bt.root.getValue(key)
+}
+
+// Sync commits the written blocks to disk.
+func (bt *BTree) Sync() error {
+	return bt.file.Sync()
 }
 
 func (bt *BTree) SetRootNode(n *bTreeNode) {
@@ -49,9 +100,15 @@ func (bt *BTree) SetRootNode(n *bTreeNode) {
 }
 
 func (bt *BTree) Close() error {
+	if err := bt.root.bs.pager.close(); err != nil {
+		return err
+	}
 	if err := bt.file.Close(); err != nil {
 		return err
 	}
+	if bt.keep {
+		return nil
+	}
 
 	_, err := os.Stat(bt.path)
 	if os.IsNotExist(err) {
@@ -0,0 +1,110 @@
+package btree
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	nonceSize    = 12
+	tagSize      = 16
+	sealOverhead = nonceSize + tagSize
+
+	keyCheckText = "query-counter"
+	keyCheckSize = sealOverhead + len(keyCheckText)
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("database key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data as nonce, ciphertext and tag. The additional data binds
+// the result to its place in the file.
+func seal(aead cipher.AEAD, data []byte, additional []byte) ([]byte, error) {
+	sealed := make([]byte, nonceSize, nonceSize+len(data)+tagSize)
+	if _, err := io.ReadFull(rand.Reader, sealed); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[:nonceSize], data, additional), nil
+}
+
+func unseal(aead cipher.AEAD, sealed []byte, additional []byte) ([]byte, error) {
+	if len(sealed) < sealOverhead {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	return aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additional)
+}
+
+// newKeyCheck seals a known text, so a wrong key is detected on open rather
+// than on the first block read.
+func newKeyCheck(key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, []byte(keyCheckText), []byte(headerMagic))
+}
+
+func (h *header) checkKey(key []byte) error {
+	if h.flags&flagEncrypted == 0 {
+		if len(key) > 0 {
+			return errors.New("database is not encrypted, but a key is given")
+		}
+		return nil
+	}
+	if len(key) == 0 {
+		return errors.New("database is encrypted, a key is required")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	if _, err := unseal(aead, h.keyCheck, []byte(headerMagic)); err != nil {
+		return errors.New("wrong database key")
+	}
+	return nil
+}
+
+// gcmPager encrypts pages before handing them to the underlying pager. Each
+// page gets a fresh nonce stored in front of it and the block id is
+// authenticated, so blocks can not be swapped inside the file.
+type gcmPager struct {
+	pager
+	aead cipher.AEAD
+}
+
+func newGCMPager(inner pager, key []byte) (*gcmPager, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmPager{pager: inner, aead: aead}, nil
+}
+
+func (p *gcmPager) readPage(id uint64) ([]byte, error) {
+	sealed, err := p.pager.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+	page, err := unseal(p.aead, sealed, uint64ToBytes(id))
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %v", id, err)
+	}
+	return page, nil
+}
+
+func (p *gcmPager) writePage(id uint64, page []byte) error {
+	sealed, err := seal(p.aead, page, uint64ToBytes(id))
+	if err != nil {
+		return err
+	}
+	return p.pager.writePage(id, sealed)
+}
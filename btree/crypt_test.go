@@ -0,0 +1,116 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSealRoundTrip(t *testing.T) {
+	aead, err := newAEAD(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("iphone 12")
+	sealed, err := seal(aead, data, uint64ToBytes(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(data)+sealOverhead {
+		t.Errorf("sealed size %d, want %d", len(sealed), len(data)+sealOverhead)
+	}
+	if bytes.Contains(sealed, data) {
+		t.Error("sealed data holds the plaintext")
+	}
+	opened, err := unseal(aead, sealed, uint64ToBytes(7))
+	if err != nil || !bytes.Equal(opened, data) {
+		t.Errorf("unseal: %q %v", opened, err)
+	}
+
+	// a block moved to another id
+	if _, err := unseal(aead, sealed, uint64ToBytes(8)); err == nil {
+		t.Error("unseal with other additional data succeeded")
+	}
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := unseal(aead, tampered, uint64ToBytes(7)); err == nil {
+			t.Errorf("unseal with byte %d flipped succeeded", i)
+		}
+	}
+	if _, err := unseal(aead, sealed[:sealOverhead-1], uint64ToBytes(7)); err == nil {
+		t.Error("unseal of truncated data succeeded")
+	}
+}
+
+func TestEncryptedBTree(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			config := Config{Compress: compress, Key: testKey, Keep: true}
+			bt, err := NewBTree(path, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 500; i++ {
+				if err := bt.Insert(NewPairs(fmt.Sprintf("secret query %03d", i), uint64(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := bt.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte("secret query")) {
+				t.Error("file holds a plaintext key")
+			}
+
+			if _, err := NewBTree(path, Config{Keep: true}); err == nil {
+				t.Error("opened without a key")
+			}
+			wrong := append([]byte(nil), testKey...)
+			wrong[0] ^= 1
+			if _, err := NewBTree(path, Config{Key: wrong, Keep: true}); err == nil {
+				t.Error("opened with a wrong key")
+			}
+
+			bt, err = NewBTree(path, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, ok, err := bt.Get("secret query 123")
+			if err != nil || !ok || value != 123 {
+				t.Errorf("Get: %d %v %v, want 123", value, ok, err)
+			}
+			if err := bt.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// flip bytes all over the blocks past the header, extents of
+			// compressed blocks are not aligned to the block size
+			blockSize := int(newHeader(config).blockSize)
+			for offset := blockSize + 100; offset < len(data); offset += 256 {
+				data[offset] ^= 1
+			}
+			if err := os.WriteFile(path, data, 0666); err != nil {
+				t.Fatal(err)
+			}
+			bt, err = NewBTree(path, config)
+			if err == nil {
+				err = bt.Ascend(func(key string, value uint64) error { return nil })
+				bt.Close()
+			}
+			if err == nil {
+				t.Error("tampered file was read")
+			}
+		})
+	}
+}
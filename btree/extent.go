@@ -0,0 +1,257 @@
+package btree
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	extentHeaderSize = 16
+	extentAlign      = 64
+	freeExtentID     = ^uint64(0)
+)
+
+type extent struct {
+	offset   int64
+	capacity uint32
+	// generation tells the newest of two extents of a block, it is kept in
+	// the top byte of the used length
+	generation uint8
+}
+
+// end returns the offset right after the extent.
+func (e extent) end() int64 {
+	return e.offset + extentHeaderSize + int64(e.capacity)
+}
+
+// extentPager stores variable-size pages as extents appended after the
+// header. Every extent starts with its block id, capacity and used length,
+// so the id mapping is rebuilt by scanning the file on open. A page that
+// outgrows its extent is written to a new one of the next generation before
+// the old extent is freed, so a crash in between leaves two extents of the
+// block and the scan keeps the newer. Adjacent free extents are merged and a
+// bigger free extent is split when it is reused.
+type extentPager struct {
+	file    *os.File
+	extents map[uint64]extent
+	// free extents by offset
+	free []extent
+	end  int64
+}
+
+func newExtentPager(file *os.File, start int64) (*extentPager, error) {
+	p := &extentPager{file: file, extents: make(map[uint64]extent), end: start}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, extentHeaderSize)
+	var stale []extent
+	for p.end < info.Size() {
+		if _, err := file.ReadAt(head, p.end); err != nil {
+			return nil, err
+		}
+		id := uint64FromBytes(head)
+		e := extent{
+			offset:     p.end,
+			capacity:   binary.LittleEndian.Uint32(head[8:]),
+			generation: head[15],
+		}
+		if e.capacity == 0 {
+			return nil, fmt.Errorf("corrupted extent at offset %d", p.end)
+		}
+		p.end = e.end()
+		if id == freeExtentID {
+			p.addFree(e)
+			continue
+		}
+		if old, ok := p.extents[id]; ok {
+			// the one written later is a generation ahead
+			if int8(e.generation-old.generation) < 0 {
+				old, e = e, old
+			}
+			stale = append(stale, old)
+		}
+		p.extents[id] = e
+	}
+	for _, e := range stale {
+		if err := p.release(e); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *extentPager) readPage(id uint64) ([]byte, error) {
+	e, ok := p.extents[id]
+	if !ok {
+		return nil, fmt.Errorf("block %d not found", id)
+	}
+	head := make([]byte, extentHeaderSize)
+	if _, err := p.file.ReadAt(head, e.offset); err != nil {
+		return nil, err
+	}
+	page := make([]byte, binary.LittleEndian.Uint32(head[12:])&usedMask)
+	if _, err := p.file.ReadAt(page, e.offset+extentHeaderSize); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (p *extentPager) writePage(id uint64, page []byte) error {
+	old, ok := p.extents[id]
+	if ok && int(old.capacity) >= len(page) {
+		return p.writeExtent(old, id, page)
+	}
+	e, err := p.allocate(len(page))
+	if err != nil {
+		return err
+	}
+	e.generation = old.generation + 1
+	if err := p.writeExtent(e, id, page); err != nil {
+		p.addFree(e)
+		return err
+	}
+	p.extents[id] = e
+	if !ok {
+		return nil
+	}
+	return p.release(old)
+}
+
+// usedMask takes the used length out of the field shared with the
+// generation, pages are far below 16MB.
+const usedMask = 1<<24 - 1
+
+func (p *extentPager) writeExtent(e extent, id uint64, page []byte) error {
+	buf := make([]byte, extentHeaderSize+len(page))
+	copy(buf, uint64ToBytes(id))
+	binary.LittleEndian.PutUint32(buf[8:], e.capacity)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(page)))
+	buf[15] = e.generation
+	copy(buf[extentHeaderSize:], page)
+	_, err := p.file.WriteAt(buf, e.offset)
+	return err
+}
+
+// release frees e merged with the free extents right before and after it.
+// A single header written at the start of the merged extent frees it all.
+func (p *extentPager) release(e extent) error {
+	i := p.addFree(e)
+	return p.writeExtent(p.free[i], freeExtentID, nil)
+}
+
+// addFree adds e to the free extents, merged with its neighbours, and
+// returns the index of the extent holding it.
+func (p *extentPager) addFree(e extent) int {
+	i := sort.Search(len(p.free), func(i int) bool { return p.free[i].offset > e.offset })
+	p.free = append(p.free, extent{})
+	copy(p.free[i+1:], p.free[i:])
+	p.free[i] = extent{offset: e.offset, capacity: e.capacity}
+	if i+1 < len(p.free) && p.free[i].end() == p.free[i+1].offset {
+		p.free[i].capacity += extentHeaderSize + p.free[i+1].capacity
+		p.free = append(p.free[:i+1], p.free[i+2:]...)
+	}
+	if i > 0 && p.free[i-1].end() == p.free[i].offset {
+		p.free[i-1].capacity += extentHeaderSize + p.free[i].capacity
+		p.free = append(p.free[:i], p.free[i+1:]...)
+		i--
+	}
+	return i
+}
+
+// allocate reuses the first free extent big enough for size bytes, split
+// when the rest is big enough to be reused too, or appends a new one with
+// some room to grow.
+func (p *extentPager) allocate(size int) (extent, error) {
+	capacity := uint32((size + size/8 + extentAlign - 1) / extentAlign * extentAlign)
+	for i, e := range p.free {
+		if int(e.capacity) < size {
+			continue
+		}
+		p.free = append(p.free[:i], p.free[i+1:]...)
+		if e.capacity >= capacity+extentHeaderSize+extentAlign {
+			// the rest is marked free before the extent is shortened, so a
+			// crash in between leaves the whole extent free
+			rest := extent{offset: e.offset + extentHeaderSize + int64(capacity), capacity: e.capacity - capacity - extentHeaderSize}
+			if err := p.writeExtent(rest, freeExtentID, nil); err != nil {
+				p.addFree(e)
+				return extent{}, err
+			}
+			p.addFree(rest)
+			e.capacity = capacity
+		}
+		return e, nil
+	}
+	e := extent{offset: p.end, capacity: capacity}
+	p.end = e.end()
+	return e, nil
+}
+
+func (p *extentPager) close() error {
+	return nil
+}
+
+func (p *extentPager) pageCount() (uint64, error) {
+	var count uint64
+	for id := range p.extents {
+		if id+1 > count {
+			count = id + 1
+		}
+	}
+	return count, nil
+}
+
+// flatePager compresses pages before handing them to the underlying pager.
+type flatePager struct {
+	pager
+	pageSize int
+	buf      bytes.Buffer
+	writer   *flate.Writer
+	reader   io.ReadCloser
+}
+
+func newFlatePager(inner pager, pageSize int) (*flatePager, error) {
+	writer, err := flate.NewWriter(nil, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	return &flatePager{
+		pager:    inner,
+		pageSize: pageSize,
+		writer:   writer,
+		reader:   flate.NewReader(nil),
+	}, nil
+}
+
+func (p *flatePager) readPage(id uint64) ([]byte, error) {
+	compressed, err := p.pager.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.reader.(flate.Resetter).Reset(bytes.NewReader(compressed), nil); err != nil {
+		return nil, err
+	}
+	page := make([]byte, p.pageSize)
+	if _, err := io.ReadFull(p.reader, page); err != nil {
+		return nil, fmt.Errorf("block %d: %v", id, err)
+	}
+	return page, nil
+}
+
+func (p *flatePager) writePage(id uint64, page []byte) error {
+	p.buf.Reset()
+	p.writer.Reset(&p.buf)
+	if _, err := p.writer.Write(page); err != nil {
+		return err
+	}
+	if err := p.writer.Close(); err != nil {
+		return err
+	}
+	return p.pager.writePage(id, p.buf.Bytes())
+}
@@ -0,0 +1,123 @@
+package btree
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestExtents(t *testing.T, path string) (*os.File, *extentPager) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newExtentPager(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, p
+}
+
+func testPage(id uint64, size int) []byte {
+	return bytes.Repeat([]byte{byte(id + 1)}, size)
+}
+
+func checkPages(t *testing.T, p *extentPager, pages map[uint64][]byte) {
+	t.Helper()
+	for id, want := range pages {
+		got, err := p.readPage(id)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("block %d: %d bytes, want %d: %v", id, len(got), len(want), err)
+		}
+	}
+}
+
+func TestExtentsMergeFreed(t *testing.T) {
+	file, p := openTestExtents(t, filepath.Join(t.TempDir(), "extents"))
+	defer file.Close()
+
+	for id := uint64(0); id < 4; id++ {
+		if err := p.writePage(id, testPage(id, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// blocks 1 and 2 outgrow their adjacent extents
+	for _, id := range []uint64{1, 2} {
+		if err := p.writePage(id, testPage(id, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(p.free) != 1 {
+		t.Fatalf("free extents %v, want the two old ones merged", p.free)
+	}
+	if merged := p.free[0]; merged.offset != p.extents[0].end() || merged.end() != p.extents[3].offset {
+		t.Errorf("merged free extent %v does not span blocks 1 and 2", merged)
+	}
+
+	// a small page takes the start of the merged extent, the rest stays free
+	end := p.end
+	if err := p.writePage(4, testPage(4, 50)); err != nil {
+		t.Fatal(err)
+	}
+	if p.end != end || p.extents[4].offset != p.free[0].offset-extentHeaderSize-int64(p.extents[4].capacity) {
+		t.Errorf("block 4 at %v, free %v, end %d of %d", p.extents[4], p.free, p.end, end)
+	}
+}
+
+func TestExtentsReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extents")
+	file, p := openTestExtents(t, path)
+
+	random := rand.New(rand.NewSource(1))
+	pages := make(map[uint64][]byte)
+	var total int64
+	for i := 0; i < 2000; i++ {
+		id := uint64(random.Intn(20))
+		total -= int64(len(pages[id]))
+		pages[id] = testPage(id, 50+random.Intn(4000))
+		total += int64(len(pages[id]))
+		if err := p.writePage(id, pages[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkPages(t, p, pages)
+	// freed space is reused instead of appending forever
+	if p.end > 4*total {
+		t.Errorf("file of %d bytes for %d bytes of pages", p.end, total)
+	}
+	file.Close()
+
+	file, p = openTestExtents(t, path)
+	defer file.Close()
+	checkPages(t, p, pages)
+}
+
+func TestExtentsCrashAfterMove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extents")
+	file, p := openTestExtents(t, path)
+	for id := uint64(0); id < 3; id++ {
+		if err := p.writePage(id, testPage(id, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// block 1 is written to a new extent, the old one is not freed yet
+	old := p.extents[1]
+	moved, err := p.allocate(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved.generation = old.generation + 1
+	if err := p.writeExtent(moved, 1, testPage(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	file, p = openTestExtents(t, path)
+	defer file.Close()
+	checkPages(t, p, map[uint64][]byte{0: testPage(0, 100), 1: testPage(1, 1000), 2: testPage(2, 100)})
+	if len(p.free) != 1 || p.free[0] != (extent{offset: old.offset, capacity: old.capacity}) {
+		t.Errorf("free extents %v, want the old extent of block 1", p.free)
+	}
+}
@@ -0,0 +1,248 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	headerMagic   = "QCBT"
+	headerVersion = 1
+	headerSize    = 32 + keyCheckSize
+
+	flagCompressed = 1 << 0
+	flagPrefixKeys = 1 << 1
+	flagEncrypted  = 1 << 2
+	knownFlags     = flagCompressed | flagPrefixKeys | flagEncrypted
+
+	DefaultBlockSize   = 8192
+	DefaultMaxLeafSize = 60
+
+	minBlockSize    = 1024
+	maxBlockSize    = 1 << 20
+	blockHeaderSize = 24
+)
+
+// Config holds the on-disk layout chosen when a database file is created.
+// Once written to the file header it can not be changed for that file, an
+// existing file is only opened with a layout that matches its header.
+type Config struct {
+	// BlockSize and MaxLeafSize of zero take the values of an existing file
+	// or DefaultBlockSize and DefaultMaxLeafSize for a new one.
+	BlockSize   int
+	MaxLeafSize int
+	// Compress stores every block flate-compressed in a variable-size extent.
+	// Set for an existing file, the file must be compressed.
+	Compress bool
+	// PrefixKeys stores every key as a suffix of the previous key in the
+	// block, so a block holds as many pairs as fit instead of a fixed count.
+	// Set for an existing file, the file must store keys so.
+	PrefixKeys bool
+	// Key turns on AES-GCM encryption of every block. It must be 16, 24 or
+	// 32 bytes long and is required to open an encrypted database.
+	Key []byte
+	// Mmap reads and writes blocks through a memory mapping of the file. It
+	// is not a part of the file layout and may differ between opens.
+	Mmap bool
+	// Keep leaves the file on Close, so the database can be opened again.
+	Keep bool
+}
+
+func DefaultConfig() Config {
+	return Config{BlockSize: DefaultBlockSize, MaxLeafSize: DefaultMaxLeafSize}
+}
+
+func (c Config) validate() error {
+	return newHeader(c).validate()
+}
+
+// maxLeafSizeFor returns the biggest fan-out whose full node (elements and
+// children ids) still fits into a single block. With prefix compression the
+// bound assumes empty suffixes, the block size check does the rest.
+func maxLeafSizeFor(blockSize int, prefixKeys bool) int {
+	if prefixKeys {
+		return (blockSize - blockHeaderSize - 8) / (prefixPairHeaderSize + 8)
+	}
+	return (blockSize - blockHeaderSize - 8) / (pairSize + 8)
+}
+
+type header struct {
+	blockSize   uint64
+	maxLeafSize uint64
+	flags       uint32
+	keyCheck    []byte
+	metadata    map[string]string
+}
+
+func newHeader(c Config) *header {
+	h := &header{
+		blockSize:   uint64(c.BlockSize),
+		maxLeafSize: uint64(c.MaxLeafSize),
+		metadata:    make(map[string]string),
+	}
+	if h.blockSize == 0 {
+		h.blockSize = DefaultBlockSize
+	}
+	if h.maxLeafSize == 0 {
+		h.maxLeafSize = DefaultMaxLeafSize
+	}
+	if c.Compress {
+		h.flags |= flagCompressed
+	}
+	if c.PrefixKeys {
+		h.flags |= flagPrefixKeys
+	}
+	if len(c.Key) > 0 {
+		h.flags |= flagEncrypted
+	}
+	return h
+}
+
+func (h *header) validate() error {
+	if h.blockSize < minBlockSize || h.blockSize > maxBlockSize {
+		return fmt.Errorf("block size must be between %d and %d, got %d", minBlockSize, maxBlockSize, h.blockSize)
+	}
+	if h.blockSize&(h.blockSize-1) != 0 {
+		return fmt.Errorf("block size must be a power of two, got %d", h.blockSize)
+	}
+	if h.maxLeafSize < 3 {
+		return fmt.Errorf("fan-out must be at least 3, got %d", h.maxLeafSize)
+	}
+	if max := maxLeafSizeFor(h.pageSize(), h.flags&flagPrefixKeys != 0); h.maxLeafSize > uint64(max) {
+		return fmt.Errorf("fan-out %d does not fit into %d byte block, max is %d", h.maxLeafSize, h.blockSize, max)
+	}
+	return nil
+}
+
+// pageSize returns the part of a block left for the node itself. Encrypted
+// blocks of fixed size keep their nonce and tag inside the block.
+func (h *header) pageSize() int {
+	if h.flags&flagEncrypted != 0 && h.flags&flagCompressed == 0 {
+		return int(h.blockSize) - sealOverhead
+	}
+	return int(h.blockSize)
+}
+
+func (h *header) toBytes() ([]byte, error) {
+	metadata, err := h.metadataBytes()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, h.blockSize)
+	copy(b, headerMagic)
+	binary.LittleEndian.PutUint32(b[4:], headerVersion)
+	copy(b[8:], uint64ToBytes(h.blockSize))
+	copy(b[16:], uint64ToBytes(h.maxLeafSize))
+	binary.LittleEndian.PutUint32(b[24:], h.flags)
+	copy(b[32:], h.keyCheck)
+	copy(b[metadataOffset:], metadata)
+	return b, nil
+}
+
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) < headerSize || string(b[:4]) != headerMagic {
+		return nil, errors.New("not a query-counter database file")
+	}
+	if version := binary.LittleEndian.Uint32(b[4:]); version != headerVersion {
+		return nil, fmt.Errorf("unsupported database version %d", version)
+	}
+	h := &header{
+		blockSize:   uint64FromBytes(b[8:]),
+		maxLeafSize: uint64FromBytes(b[16:]),
+		flags:       binary.LittleEndian.Uint32(b[24:]),
+	}
+	if h.flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("unsupported database flags %#x", h.flags)
+	}
+	if h.flags&flagEncrypted != 0 {
+		h.keyCheck = append([]byte(nil), b[32:32+keyCheckSize]...)
+	}
+	if err := h.validate(); err != nil {
+		return nil, fmt.Errorf("corrupted database header: %v", err)
+	}
+	return h, nil
+}
+
+func readHeader(file *os.File) (*header, error) {
+	b := make([]byte, headerSize)
+	if _, err := file.ReadAt(b, 0); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("database header is truncated")
+		}
+		return nil, err
+	}
+	h, err := headerFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if h.metadata, err = readMetadata(file, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func writeHeader(file *os.File, h *header) error {
+	b, err := h.toBytes()
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(b, 0)
+	return err
+}
+
+// openHeader reads the header of an existing database or writes a new one
+// built from config when the file is empty. The encryption key of config is
+// checked against the header in both cases.
+func openHeader(file *os.File, config Config) (*header, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > 0 {
+		h, err := readHeader(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.checkLayout(config); err != nil {
+			return nil, err
+		}
+		if err := h.checkKey(config.Key); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	h := newHeader(config)
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	if len(config.Key) > 0 {
+		if h.keyCheck, err = newKeyCheck(config.Key); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeHeader(file, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// checkLayout makes sure the layout asked for by config is the one of the
+// existing file, zero and false values are not checked.
+func (h *header) checkLayout(config Config) error {
+	if config.BlockSize != 0 && uint64(config.BlockSize) != h.blockSize {
+		return fmt.Errorf("database has block size %d, got %d", h.blockSize, config.BlockSize)
+	}
+	if config.MaxLeafSize != 0 && uint64(config.MaxLeafSize) != h.maxLeafSize {
+		return fmt.Errorf("database has fan-out %d, got %d", h.maxLeafSize, config.MaxLeafSize)
+	}
+	if config.Compress && h.flags&flagCompressed == 0 {
+		return errors.New("database is not compressed")
+	}
+	if config.PrefixKeys && h.flags&flagPrefixKeys == 0 {
+		return errors.New("database keys are not prefix compressed")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package btree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	journalSuffix = "-journal"
+	journalMagic  = "QCJ1"
+)
+
+// batchPager keeps the pages written between Begin and Commit in memory, so
+// the file does not change until Commit. It sits right above the pager that
+// writes the file, the kept pages are compressed and sealed already.
+type batchPager struct {
+	pager
+	// pages is nil out of a batch
+	pages map[uint64][]byte
+}
+
+func (p *batchPager) readPage(id uint64) ([]byte, error) {
+	if page, ok := p.pages[id]; ok {
+		return page, nil
+	}
+	return p.pager.readPage(id)
+}
+
+func (p *batchPager) writePage(id uint64, page []byte) error {
+	if p.pages == nil {
+		return p.pager.writePage(id, page)
+	}
+	p.pages[id] = append([]byte(nil), page...)
+	return nil
+}
+
+func (p *batchPager) pageCount() (uint64, error) {
+	count, err := p.pager.pageCount()
+	if err != nil {
+		return 0, err
+	}
+	for id := range p.pages {
+		if id+1 > count {
+			count = id + 1
+		}
+	}
+	return count, nil
+}
+
+// journal is the header block and the pages of a committed batch.
+type journal struct {
+	header []byte
+	ids    []uint64
+	pages  map[uint64][]byte
+}
+
+// writeJournal writes j to path and syncs it. The file is the header block,
+// the pages by id and a checksum of all of it, so a torn file is told from
+// a complete one.
+func writeJournal(path string, j *journal) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sum := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(file, sum))
+	w.WriteString(journalMagic)
+	binary.Write(w, binary.LittleEndian, uint32(len(j.header)))
+	w.Write(j.header)
+	binary.Write(w, binary.LittleEndian, uint32(len(j.ids)))
+	for _, id := range j.ids {
+		binary.Write(w, binary.LittleEndian, id)
+		binary.Write(w, binary.LittleEndian, uint32(len(j.pages[id])))
+		w.Write(j.pages[id])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := binary.Write(file, binary.LittleEndian, sum.Sum32()); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// readJournal reads the journal at path. A missing journal is nil, a torn
+// one is removed and nil too: the database file was not changed by its
+// batch yet.
+func readJournal(path string) (*journal, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j, ok := parseJournal(data)
+	if !ok {
+		return nil, os.Remove(path)
+	}
+	return j, nil
+}
+
+func parseJournal(data []byte) (*journal, bool) {
+	if len(data) < len(journalMagic)+4 || string(data[:len(journalMagic)]) != journalMagic {
+		return nil, false
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, false
+	}
+	b := body[len(journalMagic):]
+	next := func(n int) []byte {
+		if len(b) < n {
+			return nil
+		}
+		field := b[:n]
+		b = b[n:]
+		return field
+	}
+	length := next(4)
+	if length == nil {
+		return nil, false
+	}
+	j := &journal{header: next(int(binary.LittleEndian.Uint32(length))), pages: make(map[uint64][]byte)}
+	count := next(4)
+	if j.header == nil || count == nil {
+		return nil, false
+	}
+	for i := uint32(0); i < binary.LittleEndian.Uint32(count); i++ {
+		id, length := next(8), next(4)
+		if id == nil || length == nil {
+			return nil, false
+		}
+		page := next(int(binary.LittleEndian.Uint32(length)))
+		if page == nil {
+			return nil, false
+		}
+		j.ids = append(j.ids, uint64FromBytes(id))
+		j.pages[uint64FromBytes(id)] = page
+	}
+	return j, len(b) == 0
+}
+
+// apply writes the journal to the database file, writing it twice does no
+// harm.
+func (j *journal) apply(file *os.File, p pager) error {
+	if _, err := file.WriteAt(j.header, 0); err != nil {
+		return err
+	}
+	for _, id := range j.ids {
+		if err := p.writePage(id, j.pages[id]); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// Begin starts a batch: the changes made until Commit are kept in memory
+// and written to the file at once.
+func (bt *BTree) Begin() error {
+	batch := bt.root.bs.batch
+	if batch.pages != nil {
+		return errors.New("database batch is already started")
+	}
+	batch.pages = make(map[uint64][]byte)
+	return nil
+}
+
+// Commit writes the changes of the batch and syncs the file. They go to a
+// journal next to the database first, so a crash leaves the database as it
+// was before the batch or, once the journal is complete, as it is after:
+// NewBTree writes a complete journal again.
+func (bt *BTree) Commit() error {
+	j, err := bt.endBatch()
+	if err != nil {
+		return err
+	}
+	path := bt.path + journalSuffix
+	if err := writeJournal(path, j); err != nil {
+		return fmt.Errorf("database journal: %v", err)
+	}
+	if err := j.apply(bt.file, bt.root.bs.batch.pager); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// endBatch ends the batch and returns its journal.
+func (bt *BTree) endBatch() (*journal, error) {
+	batch := bt.root.bs.batch
+	if batch.pages == nil {
+		return nil, errors.New("database batch is not started")
+	}
+	header, err := bt.header.toBytes()
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{header: header, pages: batch.pages}
+	for id := range batch.pages {
+		j.ids = append(j.ids, id)
+	}
+	sort.Slice(j.ids, func(a, b int) bool { return j.ids[a] < j.ids[b] })
+	batch.pages = nil
+	return j, nil
+}
+
+func (bt *BTree) inBatch() bool {
+	return bt.root.bs.batch.pages != nil
+}
@@ -0,0 +1,107 @@
+package btree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crashInCommit writes the journal of a batch of n keys and closes the file
+// without writing the batch to it. torn leaves the last byte of the journal
+// out.
+func crashInCommit(t *testing.T, path string, config Config, n int, torn bool) {
+	bt, err := NewBTree(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := bt.Insert(NewPairs(fmt.Sprintf("key %04d", i), uint64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bt.SetMetadata("offset", fmt.Sprint(n)); err != nil {
+		t.Fatal(err)
+	}
+	j, err := bt.endBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJournal(path+journalSuffix, j); err != nil {
+		t.Fatal(err)
+	}
+	if torn {
+		info, err := os.Stat(path + journalSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path+journalSuffix, info.Size()-1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		for _, torn := range []bool{false, true} {
+			t.Run(fmt.Sprintf("compress=%v,torn=%v", compress, torn), func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "db")
+				config := Config{MaxLeafSize: 8, Compress: compress, Keep: true}
+
+				bt, err := NewBTree(path, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := bt.Begin(); err != nil {
+					t.Fatal(err)
+				}
+				if err := bt.Insert(NewPairs("first", 1)); err != nil {
+					t.Fatal(err)
+				}
+				if err := bt.Commit(); err != nil {
+					t.Fatal(err)
+				}
+				if err := bt.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				crashInCommit(t, path, config, 500, torn)
+
+				bt, err = NewBTree(path, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer bt.Close()
+				if _, err := os.Stat(path + journalSuffix); !os.IsNotExist(err) {
+					t.Errorf("journal is left after open: %v", err)
+				}
+				if value, ok, err := bt.Get("first"); err != nil || !ok || value != 1 {
+					t.Errorf("Get(first) = %d, %v, %v", value, ok, err)
+				}
+				want, offset := 500, "500"
+				if torn {
+					want, offset = 0, ""
+				}
+				count := 0
+				err = bt.Ascend(func(key string, value uint64) error {
+					if key != "first" {
+						count++
+					}
+					return nil
+				})
+				if err != nil || count != want {
+					t.Errorf("%d keys of the batch, want %d: %v", count, want, err)
+				}
+				if got := bt.Metadata("offset"); got != offset {
+					t.Errorf("offset %q, want %q", got, offset)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Metadata is kept in the header block right after the fixed header fields:
+// its length followed by names and values, each prefixed by its length. Files
+// written before it have zeros there, which reads as no metadata.
+const metadataOffset = headerSize
+
+func (h *header) metadataBytes() ([]byte, error) {
+	names := make([]string, 0, len(h.metadata))
+	for name := range h.metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := make([]byte, 2)
+	for _, name := range names {
+		value := h.metadata[name]
+		if len(name) > 0xff || len(value) > 0xffff {
+			return nil, fmt.Errorf("database metadata %q is too long", name)
+		}
+		b = append(b, byte(len(name)))
+		b = append(b, name...)
+		b = append(b, lenToBytes(uint16(len(value)))...)
+		b = append(b, value...)
+	}
+	if metadataOffset+len(b) > int(h.blockSize) {
+		return nil, errors.New("database metadata does not fit into the header block")
+	}
+	binary.LittleEndian.PutUint16(b, uint16(len(b)-2))
+	return b, nil
+}
+
+func metadataFromBytes(b []byte) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for len(b) > 0 {
+		nameLength := int(b[0])
+		if len(b) < 1+nameLength+2 {
+			return nil, errors.New("database metadata is truncated")
+		}
+		name := string(b[1 : 1+nameLength])
+		b = b[1+nameLength:]
+		valueLength := int(lenFromBytes(b))
+		if len(b) < 2+valueLength {
+			return nil, errors.New("database metadata is truncated")
+		}
+		metadata[name] = string(b[2 : 2+valueLength])
+		b = b[2+valueLength:]
+	}
+	return metadata, nil
+}
+
+func readMetadata(file *os.File, h *header) (map[string]string, error) {
+	b := make([]byte, int(h.blockSize)-metadataOffset)
+	if _, err := file.ReadAt(b, int64(metadataOffset)); err != nil {
+		return nil, err
+	}
+	length := int(lenFromBytes(b))
+	if 2+length > len(b) {
+		return nil, errors.New("database metadata is truncated")
+	}
+	return metadataFromBytes(b[2 : 2+length])
+}
+
+// Metadata returns the value stored under name in the database header, or an
+// empty string.
+func (bt *BTree) Metadata(name string) string {
+	return bt.header.metadata[name]
+}
+
+// SetMetadata stores a value under name in the database header, in a batch
+// the header is written by Commit. It is meant for settings that must stay
+// the same for every run over the database and for progress of the runs.
+func (bt *BTree) SetMetadata(name string, value string) error {
+	old, ok := bt.header.metadata[name]
+	bt.header.metadata[name] = value
+	write := func() error {
+		if bt.inBatch() {
+			_, err := bt.header.metadataBytes()
+			return err
+		}
+		return writeHeader(bt.file, bt.header)
+	}
+	if err := write(); err != nil {
+		if ok {
+			bt.header.metadata[name] = old
+		} else {
+			delete(bt.header.metadata, name)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package btree
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapPager keeps the whole database file mapped into memory and hands out
+// blocks as slices of the mapping instead of reading them into new buffers.
+// The mapping grows ahead of the file, the file itself is extended block by
+// block so its size still tells the number of blocks.
+type mmapPager struct {
+	file     *os.File
+	pageSize int64
+	size     int64
+	data     []byte
+}
+
+func newMmapPager(file *os.File, pageSize int64) (pager, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	p := &mmapPager{file: file, pageSize: pageSize, size: info.Size()}
+	if err := p.remap(p.size); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// remap maps length bytes of the file first and only then unmaps the old
+// mapping, so a failed call leaves the pager working with the old one.
+func (p *mmapPager) remap(length int64) error {
+	data, err := syscall.Mmap(int(p.file.Fd()), 0, int(length), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmap: %v", err)
+	}
+	old := p.data
+	p.data = data
+	if old != nil {
+		return syscall.Munmap(old)
+	}
+	return nil
+}
+
+func (p *mmapPager) offset(id uint64) int64 {
+	return (int64(id) + 1) * p.pageSize
+}
+
+// readPage returns a slice of the mapping, it is only valid until the next
+// writePage call.
+func (p *mmapPager) readPage(id uint64) ([]byte, error) {
+	offset := p.offset(id)
+	if offset+p.pageSize > p.size {
+		return nil, fmt.Errorf("block %d is out of file", id)
+	}
+	return p.data[offset : offset+p.pageSize], nil
+}
+
+func (p *mmapPager) writePage(id uint64, page []byte) error {
+	offset := p.offset(id)
+	if end := offset + int64(len(page)); end > p.size {
+		// the mapping may run past the end of the file as long as the pages
+		// past it are not touched
+		if end > int64(len(p.data)) {
+			length := 2 * int64(len(p.data))
+			if length < end {
+				length = end
+			}
+			if err := p.remap(length); err != nil {
+				return err
+			}
+		}
+		if err := p.file.Truncate(end); err != nil {
+			return err
+		}
+		p.size = end
+	}
+	copy(p.data[offset:], page)
+	return nil
+}
+
+func (p *mmapPager) pageCount() (uint64, error) {
+	if blocks := p.size/p.pageSize - 1; blocks > 0 {
+		return uint64(blocks), nil
+	}
+	return 0, nil
+}
+
+func (p *mmapPager) close() error {
+	if p.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(p.data)
+	p.data = nil
+	return err
+}
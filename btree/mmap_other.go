@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package btree
+
+import (
+	"errors"
+	"os"
+)
+
+func newMmapPager(file *os.File, pageSize int64) (pager, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
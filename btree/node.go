@@ -2,6 +2,7 @@ package btree
 
 import (
 	"os"
+	"sort"
 )
 
 type bTreeNode struct {
@@ -31,7 +32,10 @@ func (n *bTreeNode) addElement(element *pairs) int {
 }
 
 func (n *bTreeNode) hasOverFlown() bool {
-	return len(n.elements) > maxLeafSize
+	if len(n.elements) > n.bs.maxLeafSize {
+		return true
+	}
+	return n.bs.prefixKeys && n.bs.nodeSize(n) > int(n.bs.blockSize)
 }
 
 func (n *bTreeNode) getChildAtIndex(index int) (*bTreeNode, error) {
@@ -254,19 +258,19 @@ func (n *bTreeNode) searchElementInNode(key string) (uint64, bool) {
 	return 0, false
 }
 
-func (n *bTreeNode) search(key string) (uint64, error) {
+func (n *bTreeNode) search(key string) (uint64, bool, error) {
 	currentNode := n
 	for {
 		value, foundInCurrentNode := currentNode.searchElementInNode(key)
 		if foundInCurrentNode {
-			return value, nil
+			return value, true, nil
 		}
 		if currentNode.isLeaf() {
-			return 0, nil
+			return 0, false, nil
 		}
 		node, err := currentNode.getChildNodeForElement(key)
 		if err != nil {
-			return 0, err
+			return 0, false, err
 		}
 		currentNode = node
 	}
@@ -309,15 +313,77 @@ func (n *bTreeNode) writeToFile(file *os.File) error {
 	return nil
 }
 
-func (n *bTreeNode) getValue(key string) (uint64, error) {
+// ascend calls f for every pair of the subtree from start in key order.
+func (n *bTreeNode) ascend(start string, f func(key string, value uint64) error) error {
+	type frame struct {
+		node *bTreeNode
+		// next child to visit, the element before it is due once the
+		// previous child is done
+		index int
+	}
+	// walk down to the first key not less than start, the nodes on the way
+	// continue with the element after the child taken
+	var stack []frame
+	node := n
+	for {
+		i := sort.Search(len(node.elements), func(i int) bool {
+			return node.elements[i].key >= start
+		})
+		if node.isLeaf() {
+			for _, e := range node.elements[i:] {
+				if err := f(e.key, e.value); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		stack = append(stack, frame{node: node, index: i + 1})
+		child, err := node.getChildAtIndex(i)
+		if err != nil {
+			return err
+		}
+		node = child
+	}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		node := top.node
+		if node.isLeaf() {
+			for _, e := range node.elements {
+				if err := f(e.key, e.value); err != nil {
+					return err
+				}
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if top.index > 0 && top.index-1 < len(node.elements) {
+			e := node.elements[top.index-1]
+			if err := f(e.key, e.value); err != nil {
+				return err
+			}
+		}
+		if top.index == len(node.childrenBlockIds) {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		child, err := node.getChildAtIndex(top.index)
+		if err != nil {
+			return err
+		}
+		top.index++
+		stack = append(stack, frame{node: child})
+	}
+	return nil
+}
+
+func (n *bTreeNode) getValue(key string) (uint64, bool, error) {
 	return n.search(key)
 }
 
 func (n *bTreeNode) findAndUpdate(key string, value uint64) (bool, error) {
 	currentNode := n
 	for {
-		value, foundInCurrentNode := currentNode.searchElementInNode(key)
-		if foundInCurrentNode {
+		if _, foundInCurrentNode := currentNode.searchElementInNode(key); foundInCurrentNode {
 			return currentNode.update(key, value)
 		}
 		if currentNode.isLeaf() {
@@ -0,0 +1,92 @@
+package btree
+
+import (
+	"errors"
+	"os"
+)
+
+// pager stores raw block images addressed by block id. A page returned by
+// readPage must not be kept after the next writePage call.
+type pager interface {
+	readPage(id uint64) ([]byte, error)
+	writePage(id uint64, page []byte) error
+	// pageCount returns the number of block ids in use, holes included.
+	pageCount() (uint64, error)
+	close() error
+}
+
+// filePager keeps every block in a fixed-size slot right after the header.
+type filePager struct {
+	file     *os.File
+	pageSize int64
+}
+
+func newFilePager(file *os.File, pageSize int64) *filePager {
+	return &filePager{file: file, pageSize: pageSize}
+}
+
+func (p *filePager) offset(id uint64) int64 {
+	return (int64(id) + 1) * p.pageSize
+}
+
+func (p *filePager) readPage(id uint64) ([]byte, error) {
+	page := make([]byte, p.pageSize)
+	if _, err := p.file.ReadAt(page, p.offset(id)); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (p *filePager) writePage(id uint64, page []byte) error {
+	_, err := p.file.WriteAt(page, p.offset(id))
+	return err
+}
+
+func (p *filePager) close() error {
+	return nil
+}
+
+func (p *filePager) pageCount() (uint64, error) {
+	info, err := p.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	// the first slot of the file is taken by the header
+	if blocks := info.Size()/p.pageSize - 1; blocks > 0 {
+		return uint64(blocks), nil
+	}
+	return 0, nil
+}
+
+// newPager returns the pager of the blocks and the batch pager right above
+// the one writing the file.
+func newPager(file *os.File, h *header, config Config) (pager, *batchPager, error) {
+	compressed := h.flags&flagCompressed != 0
+	var p pager
+	var err error
+	switch {
+	case compressed && config.Mmap:
+		return nil, nil, errors.New("mmap can not be used with a compressed database")
+	case compressed:
+		p, err = newExtentPager(file, int64(h.blockSize))
+	case config.Mmap:
+		p, err = newMmapPager(file, int64(h.blockSize))
+	default:
+		p = newFilePager(file, int64(h.blockSize))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	batch := &batchPager{pager: p}
+	p = batch
+	if h.flags&flagEncrypted != 0 {
+		if p, err = newGCMPager(p, config.Key); err != nil {
+			return nil, nil, err
+		}
+	}
+	if compressed {
+		fp, err := newFlatePager(p, h.pageSize())
+		return fp, batch, err
+	}
+	return p, batch, nil
+}
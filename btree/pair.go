@@ -2,6 +2,7 @@ package btree
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strconv"
 )
@@ -9,8 +10,16 @@ import (
 const (
 	pairSize     = 127
 	maxKeyLength = 117
+
+	// prefixPairHeaderSize covers shared prefix length, suffix length and value
+	prefixPairHeaderSize = 12
+	maxPrefixLength      = 1<<16 - 1
 )
 
+// ErrKeyTooLong is returned by Insert for a key longer than the block format
+// of the database holds.
+var ErrKeyTooLong = errors.New("key is too long")
+
 type pairs struct {
 	keyLen uint16 //2
 	key    string //117
@@ -37,9 +46,9 @@ func (p *pairs) setValue(value uint64) {
 	p.value = value
 }
 
-func (p *pairs) validate() error {
-	if len(p.key) > maxKeyLength {
-		return fmt.Errorf("key max lenght 117, currently it is %d", len(p.key))
+func (p *pairs) validate(maxLength int) error {
+	if len(p.key) > maxLength {
+		return fmt.Errorf("%w: max length %d, currently it is %d", ErrKeyTooLong, maxLength, len(p.key))
 	}
 	return nil
 }
@@ -66,6 +75,55 @@ func (p *pairs) convertToPair(bPairs []byte) {
 	p.value = uint64FromBytes(bPairs[offset:])
 }
 
+// prefixSize returns the size of the pair stored relative to the previous key
+// of the same block.
+func (p *pairs) prefixSize(prev string) int {
+	return prefixPairHeaderSize + len(p.key) - sharedPrefixLength(prev, p.key)
+}
+
+func (p *pairs) convertToPrefixBytes(prev string) []byte {
+	shared := sharedPrefixLength(prev, p.key)
+	bPairs := make([]byte, p.prefixSize(prev))
+	offset := 0
+	copy(bPairs[offset:], lenToBytes(uint16(shared)))
+	offset += 2
+	copy(bPairs[offset:], lenToBytes(uint16(len(p.key)-shared)))
+	offset += 2
+	copy(bPairs[offset:], p.key[shared:])
+	offset += len(p.key) - shared
+	copy(bPairs[offset:], uint64ToBytes(p.value))
+	return bPairs
+}
+
+// convertToPrefixPair decodes a pair written by convertToPrefixBytes and
+// returns the number of bytes consumed.
+func (p *pairs) convertToPrefixPair(bPairs []byte, prev string) int {
+	offset := 0
+	shared := int(lenFromBytes(bPairs[offset:]))
+	offset += 2
+	suffixLen := int(lenFromBytes(bPairs[offset:]))
+	offset += 2
+	p.setKey(prev[:shared] + string(bPairs[offset:offset+suffixLen]))
+	offset += suffixLen
+	p.value = uint64FromBytes(bPairs[offset:])
+	return offset + 8
+}
+
+func sharedPrefixLength(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	if n > maxPrefixLength {
+		n = maxPrefixLength
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
 func lenFromBytes(b []byte) uint16 {
 	return binary.LittleEndian.Uint16(b)
 }
@@ -0,0 +1,100 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefixPairRoundTrip(t *testing.T) {
+	tests := []struct {
+		prev, key string
+		shared    int
+	}{
+		{"", "iphone", 0},
+		{"iphone", "iphone 12", 6},
+		{"iphone 12", "iphone 13", 8},
+		{"iphone 13", "ipad", 2},
+		{"ipad", "ipad", 4},
+		// bytes are shared, the first byte of ш and т too
+		{"кошка", "кот", 5},
+		{"abc", "", 0},
+	}
+	for _, tt := range tests {
+		p := NewPairs(tt.key, 42)
+		b := p.convertToPrefixBytes(tt.prev)
+		if len(b) != prefixPairHeaderSize+len(tt.key)-tt.shared {
+			t.Errorf("%q after %q: size %d, want %d", tt.key, tt.prev, len(b), prefixPairHeaderSize+len(tt.key)-tt.shared)
+		}
+		decoded := EmptyPairs()
+		n := decoded.convertToPrefixPair(append(b, 0xff), tt.prev)
+		if n != len(b) {
+			t.Errorf("%q after %q: consumed %d bytes, want %d", tt.key, tt.prev, n, len(b))
+		}
+		if decoded.key != tt.key || decoded.value != 42 {
+			t.Errorf("%q after %q: decoded %q=%d", tt.key, tt.prev, decoded.key, decoded.value)
+		}
+	}
+}
+
+func TestPrefixKeysBTree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	config := Config{BlockSize: 1024, MaxLeafSize: 40, PrefixKeys: true, Keep: true}
+	bt, err := NewBTree(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 200)
+	const n = 2000
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("%s %05d", long, (i*7919)%n)
+		if err := bt.Insert(NewPairs(key, uint64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bt, err = NewBTree(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bt.Close()
+	prev, count := "", 0
+	err = bt.Ascend(func(key string, value uint64) error {
+		if key <= prev {
+			t.Errorf("key %q after %q", key, prev)
+		}
+		prev = key
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("got %d keys, want %d", count, n)
+	}
+	key := fmt.Sprintf("%s %05d", long, 7919%n)
+	if value, ok, err := bt.Get(key); err != nil || !ok || value != 1 {
+		t.Errorf("Get: %d %v %v, want 1", value, ok, err)
+	}
+}
+
+func TestInsertKeyTooLong(t *testing.T) {
+	bt, err := NewBTree(filepath.Join(t.TempDir(), "db"), DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bt.Close()
+	err = bt.Insert(NewPairs(strings.Repeat("x", bt.MaxKeyLength()+1), 1))
+	if !errors.Is(err, ErrKeyTooLong) {
+		t.Errorf("got %v, want ErrKeyTooLong", err)
+	}
+	if err := bt.Insert(NewPairs(strings.Repeat("x", bt.MaxKeyLength()), 1)); err != nil {
+		t.Error(err)
+	}
+}
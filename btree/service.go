@@ -3,15 +3,32 @@ package btree
 import "os"
 
 type bTreeNodeService struct {
-	file *os.File
+	file   *os.File
+	header *header
+	config Config
+	// journal is a committed batch to write again before the blocks are read
+	journal *journal
 }
 
-func newBTreeNodeService(file *os.File) *bTreeNodeService {
-	return &bTreeNodeService{file: file}
+func newBTreeNodeService(file *os.File, header *header, config Config, journal *journal) *bTreeNodeService {
+	return &bTreeNodeService{file: file, header: header, config: config, journal: journal}
 }
 
 func (ns *bTreeNodeService) getRootNode() (*bTreeNode, error) {
-	bs := &bTreeBlockService{file: ns.file, lastBlockIndex: 0}
+	pager, batch, err := newPager(ns.file, ns.header, ns.config)
+	if err != nil {
+		return nil, err
+	}
+	if ns.journal != nil {
+		if err := ns.journal.apply(ns.file, batch.pager); err != nil {
+			return nil, err
+		}
+	}
+	bs, err := newBlockService(pager, ns.header)
+	if err != nil {
+		return nil, err
+	}
+	bs.batch = batch
 	rootBlock, err := bs.rootBlock()
 	if err != nil {
 		return nil, err
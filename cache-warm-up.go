@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"query-counter/btree"
+	"query-counter/lru"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// topEntries is a min-heap of entries by value.
+type topEntries []lru.Entry
+
+func (t topEntries) Len() int           { return len(t) }
+func (t topEntries) Less(i, j int) bool { return t[i].Value < t[j].Value }
+func (t topEntries) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+func (t *topEntries) Push(x interface{}) {
+	*t = append(*t, x.(lru.Entry))
+}
+
+func (t *topEntries) Pop() interface{} {
+	old := *t
+	e := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return e
+}
+
+// add keeps the n entries of the highest values.
+func (t *topEntries) add(e lru.Entry, n int) {
+	if len(*t) < n {
+		heap.Push(t, e)
+		return
+	}
+	if e.Value > (*t)[0].Value {
+		(*t)[0] = e
+		heap.Fix(t, 0)
+	}
+}
+
+// WarmUp fills the cache with the n keys of the highest counts in the DB, so
+// a resumed run does not start with a cold cache. The cached counters start
+// at zero as the counts are already in the DB. It must be called before
+// InitWorkers.
+func (qw *QueryWorker) WarmUp(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	top := make(topEntries, 0, n)
+	err := qw.db.Ascend(func(key string, value uint64) error {
+		top.add(lru.Entry{Key: key, Value: value}, n)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the hottest keys go last to be the most recently used
+	for top.Len() > 0 {
+		e := heap.Pop(&top).(lru.Entry)
+		qw.cache.PushOrIncrement(e.Key, 0)
+	}
+	return nil
+}
+
+// A cache snapshot is the id of the snapshot on the first line followed by
+// the cache entries. The cached counters are not written to the DB when the
+// snapshot is saved, the DB records the id of the snapshot holding them
+// instead and the first write after the snapshot is loaded clears it. Until
+// then the DB is only read together with the snapshot.
+
+// openSnapshot opens the cache snapshot at path after its id, it returns a
+// nil file when the DB records no snapshot and misses no counts.
+func openSnapshot(db *btree.BTree, path string) (*os.File, *bufio.Reader, error) {
+	id := db.Metadata(metadataCacheSnapshot)
+	if id == "" {
+		return nil, nil, nil
+	}
+	if path == "" {
+		return nil, nil, fmt.Errorf("DB counts are partly in cache snapshot %s, pass it with --cache-snapshot", id)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("DB counts are partly in cache snapshot %s: %v", id, err)
+	}
+	r := bufio.NewReader(file)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("cache snapshot: %v", err)
+	}
+	if line = strings.TrimSuffix(line, "\n"); line != id {
+		file.Close()
+		return nil, nil, fmt.Errorf("cache snapshot %q is %s, DB counts are partly in %s", path, line, id)
+	}
+	return file, r, nil
+}
+
+// addSnapshotCounts adds the counters of the snapshot the DB records to it in
+// a batch that is never committed, so the DB file is left without them.
+func addSnapshotCounts(db *btree.BTree, path string) error {
+	file, r, err := openSnapshot(db, path)
+	if err != nil || file == nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := db.Begin(); err != nil {
+		return err
+	}
+	var addErr error
+	err = lru.ReadSnapshot(r, func(key string, value uint64) {
+		if addErr == nil && value != 0 {
+			// keys too long for the DB are left out as in the run
+			if addErr = addCount(db, key, value); errors.Is(addErr, btree.ErrKeyTooLong) {
+				addErr = nil
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return addErr
+}
+
+// LoadSnapshot restores the cache saved by the previous run when the DB
+// records its id. It must be called before InitFlusher and ResultProcessing.
+func (qw *QueryWorker) LoadSnapshot(path string) error {
+	qw.snapshot = path
+	file, r, err := openSnapshot(qw.db, path)
+	if err != nil || file == nil {
+		return err
+	}
+	defer file.Close()
+
+	// nothing writes the DB yet, ResultProcessing writes them first
+	qw.cache.OnEvict(func(key string, value uint64) {
+		if value != 0 {
+			qw.loadEvicted = append(qw.loadEvicted, counterWrite{key: key, value: value})
+		}
+	})
+	defer qw.cache.OnEvict(qw.evict)
+	if err := qw.cache.LoadFrom(r); err != nil {
+		return err
+	}
+	qw.snapshotLoaded = true
+	return nil
+}
+
+// saveSnapshot writes the cache to the snapshot under a new id, the DB
+// records the id with the next checkpoint.
+func (qw *QueryWorker) saveSnapshot() error {
+	id := strconv.FormatInt(time.Now().UnixNano(), 10)
+	tmp := qw.snapshot + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(id + "\n"); err != nil {
+		return err
+	}
+	if err := qw.cache.SaveTo(file); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, qw.snapshot); err != nil {
+		return err
+	}
+	qw.snapshotID = id
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"query-counter/btree"
+	"query-counter/lru"
+	"sort"
+	"testing"
+)
+
+// newTestDB creates a DB holding key-00..key-<n-1> counted by their number.
+func newTestDB(t *testing.T, n int) *btree.BTree {
+	db, err := btree.NewBTree(filepath.Join(t.TempDir(), "db"), btree.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for i := 0; i < n; i++ {
+		if err := db.Insert(btree.NewPairs(fmt.Sprintf("key-%02d", i), uint64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestWarmUp(t *testing.T) {
+	db := newTestDB(t, 20)
+	for _, c := range []struct {
+		n    int
+		want string
+	}{
+		{0, "[]"},
+		{3, "[key-17 key-18 key-19]"},
+		// more keys than the cache holds leave the hottest ones
+		{50, "[key-15 key-16 key-17 key-18 key-19]"},
+	} {
+		cache, err := lru.NewLRU(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		qw, err := NewQueryWorker(db, 1, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qw.WarmUp(c.n); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		cache.Range(func(key string, value uint64) {
+			keys = append(keys, key)
+			if value != 0 {
+				t.Errorf("warm-up %d: %s cached with %d", c.n, key, value)
+			}
+		})
+		sort.Strings(keys)
+		if got := fmt.Sprint(keys); got != c.want {
+			t.Errorf("warm-up %d cached %s, want %s", c.n, got, c.want)
+		}
+		if len(qw.results) != 0 {
+			t.Errorf("warm-up %d wrote %d counters", c.n, len(qw.results))
+		}
+	}
+}
+
+// runSnapshotted counts queries into the DB at path with a cache of size
+// entries saved to snapshot.
+func runSnapshotted(t *testing.T, path, snapshot string, size int, queries []query) {
+	db := openKeptDB(t, path)
+	defer db.Close()
+	cache, err := lru.NewLRU(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qw, err := NewQueryWorker(db, 1, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := qw.LoadSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	qw.InitWorkers()
+	go qw.ResultProcessing()
+	qw.Send(queries, 1)
+	qw.Close()
+	qw.Wait()
+}
+
+func openKeptDB(t *testing.T, path string) *btree.BTree {
+	db, err := btree.NewBTree(path, btree.Config{Keep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSnapshotRequired(t *testing.T) {
+	dir := t.TempDir()
+	path, snapshot := filepath.Join(dir, "db"), filepath.Join(dir, "snapshot")
+	runSnapshotted(t, path, snapshot, 3, []query{{key: "a", vale: 2}, {key: "b", vale: 1}})
+
+	db := openKeptDB(t, path)
+	defer db.Close()
+	if _, ok, _ := db.Get("a"); ok {
+		t.Error("counter of the snapshot is in the DB")
+	}
+	cache, _ := lru.NewLRU(3)
+	qw, _ := NewQueryWorker(db, 1, cache)
+	if err := qw.LoadSnapshot(""); err == nil {
+		t.Error("loaded a DB with counts in a snapshot without it")
+	}
+	if err := addSnapshotCounts(db, ""); err == nil {
+		t.Error("read a DB with counts in a snapshot without it")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other"), []byte("1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := addSnapshotCounts(db, filepath.Join(dir, "other")); err == nil {
+		t.Error("read a DB with another snapshot")
+	}
+	if err := addSnapshotCounts(db, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := db.Get("a"); value != 2 {
+		t.Errorf("a counted %d with the snapshot, want 2", value)
+	}
+}
+
+func TestSnapshotInterrupted(t *testing.T) {
+	for _, checkpoints := range []bool{false, true} {
+		t.Run(fmt.Sprintf("checkpoints=%v", checkpoints), func(t *testing.T) {
+			dir := t.TempDir()
+			path, snapshot := filepath.Join(dir, "db"), filepath.Join(dir, "snapshot")
+			runSnapshotted(t, path, snapshot, 3, []query{{key: "a", vale: 2}, {key: "b", vale: 1}})
+
+			// the next run evicts the loaded a and stops before a checkpoint
+			db := openKeptDB(t, path)
+			cache, _ := lru.NewLRU(2)
+			qw, _ := NewQueryWorker(db, 1, cache)
+			if err := qw.LoadSnapshot(snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if checkpoints {
+				// as InitFlusher does
+				qw.held = make(map[string]uint64)
+			}
+			cache.PushOrIncrement("c", 1)
+			qw.process(<-qw.results)
+			db.Close()
+
+			db = openKeptDB(t, path)
+			defer db.Close()
+			// with checkpoints a stays in the snapshot, otherwise it is
+			// written with the snapshot id cleared
+			if recorded := db.Metadata(metadataCacheSnapshot) != ""; recorded != checkpoints {
+				t.Errorf("DB records the snapshot: %v, want %v", recorded, checkpoints)
+			}
+			if err := addSnapshotCounts(db, snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if value, _, _ := db.Get("a"); value != 2 {
+				t.Errorf("a counted %d, want 2", value)
+			}
+		})
+	}
+}
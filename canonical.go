@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// wordSignature returns the words of a query in sorted order, so the
+// reorderings of a query have the same signature.
+func wordSignature(query string) string {
+	w := strings.Fields(query)
+	sort.Strings(w)
+	return strings.Join(w, " ")
+}
+
+// deletions returns s with one rune deleted at every position.
+func deletions(s string) []string {
+	variants := make([]string, 0, len(s))
+	for i, r := range s {
+		variants = append(variants, s[:i]+s[i+utf8.RuneLen(r):])
+	}
+	return variants
+}
+
+// isTypo tells whether a turns into b by one insertion, deletion or
+// substitution of a rune. Edits of digits are not typos, they tell models,
+// sizes and years apart.
+func isTypo(a string, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb)-len(ra) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(ra) && ra[i] == rb[i] {
+		i++
+	}
+	if i == len(rb) {
+		return false
+	}
+	if unicode.IsDigit(rb[i]) || (len(ra) == len(rb) && unicode.IsDigit(ra[i])) {
+		return false
+	}
+	// the rest must match after the edit
+	if len(ra) == len(rb) {
+		return string(ra[i+1:]) == string(rb[i+1:])
+	}
+	return string(ra[i:]) == string(rb[i+1:])
+}
+
+// clusterQueries groups queries that are reorderings of each other's words
+// or within one edit of the most counted one of the group and returns the
+// group of every query. Groups are taken by descending count, a query joins
+// the first one it is close to, so two queries of a group are at most two
+// edits apart and chains of typos do not merge distinct queries. Signatures
+// shorter than minLength runes are only grouped by reordering, typos in
+// short queries are too likely to be other words.
+func clusterQueries(queries []string, counts []uint64, minLength int) []int {
+	signatures := make(map[string]int)
+	signatureOf := make([]int, len(queries))
+	var unique []string
+	var total []uint64
+	for i, q := range queries {
+		sig := wordSignature(q)
+		id, ok := signatures[sig]
+		if !ok {
+			id = len(unique)
+			signatures[sig] = id
+			unique = append(unique, sig)
+			total = append(total, 0)
+		}
+		signatureOf[i] = id
+		total[id] += counts[i]
+	}
+
+	// two strings one edit apart share one of them or a deletion, the
+	// candidates are checked as sharing one is not enough
+	index := make(map[string][]int)
+	for id, sig := range unique {
+		if utf8.RuneCountInString(sig) < minLength {
+			continue
+		}
+		for _, variant := range append(deletions(sig), sig) {
+			index[variant] = append(index[variant], id)
+		}
+	}
+
+	order := make([]int, len(unique))
+	for id := range order {
+		order[id] = id
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if total[a] != total[b] {
+			return total[a] > total[b]
+		}
+		return unique[a] < unique[b]
+	})
+	groupOf := make([]int, len(unique))
+	for id := range groupOf {
+		groupOf[id] = -1
+	}
+	for _, id := range order {
+		if groupOf[id] >= 0 {
+			continue
+		}
+		groupOf[id] = id
+		if utf8.RuneCountInString(unique[id]) < minLength {
+			continue
+		}
+		for _, variant := range append(deletions(unique[id]), unique[id]) {
+			for _, other := range index[variant] {
+				if groupOf[other] < 0 && isTypo(unique[id], unique[other]) {
+					groupOf[other] = id
+				}
+			}
+		}
+	}
+
+	groups := make([]int, len(queries))
+	for i := range queries {
+		groups[i] = groupOf[signatureOf[i]]
+	}
+	return groups
+}
+
+// runCanonical writes every query with its count, its canonical query and
+// the count of the whole group. The canonical query of a group is its most
+// counted one. Counts are summed over dimensions and time buckets.
+func runCanonical(args []string) error {
+	fs := flag.NewFlagSet("canonical", flag.ExitOnError)
+	dbFlags := addDBFlags(fs)
+	var minLength = fs.Int("min-length", 5, "Shortest query to group with the ones within one typo")
+	var maxKeys = fs.Int("max-keys", 1000000, "Most queries to group, all of them are kept in memory")
+	var outputPath = fs.String("output", "", "Result file, standard output when empty")
+	fs.Parse(args)
+	if *maxKeys <= 0 {
+		return fmt.Errorf("bad max-keys %d", *maxKeys)
+	}
+
+	db, err := dbFlags.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	format, err := keyFormatOf(db)
+	if err != nil {
+		return err
+	}
+
+	// keys of a query follow each other, its query field comes first
+	var queries []string
+	var counts []uint64
+	err = db.Ascend(func(key string, value uint64) error {
+		query := key
+		if format.composite() {
+			fields, err := format.split(key)
+			if err != nil {
+				return err
+			}
+			query = fields[0]
+		}
+		if n := len(queries); n > 0 && queries[n-1] == query {
+			counts[n-1] += value
+			return nil
+		}
+		if len(queries) == *maxKeys {
+			return fmt.Errorf("DB has more than %d queries, raise --max-keys to group them", *maxKeys)
+		}
+		queries = append(queries, query)
+		counts = append(counts, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	groups := clusterQueries(queries, counts, *minLength)
+	canonical := make(map[int]int)
+	totals := make(map[int]uint64)
+	for i, g := range groups {
+		totals[g] += counts[i]
+		if c, ok := canonical[g]; !ok || counts[i] > counts[c] {
+			canonical[g] = i
+		}
+	}
+
+	return writeOutput(*outputPath, func(w *bufio.Writer) error {
+		for i, q := range queries {
+			g := groups[i]
+			w.WriteString(q)
+			w.WriteByte('\t')
+			w.WriteString(strconv.FormatUint(counts[i], 10))
+			w.WriteByte('\t')
+			w.WriteString(queries[canonical[g]])
+			w.WriteByte('\t')
+			w.WriteString(strconv.FormatUint(totals[g], 10))
+			w.WriteByte('\n')
+		}
+		return nil
+	})
+}
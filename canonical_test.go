@@ -0,0 +1,131 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsTypo(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"iphone", "iphon", true},
+		{"iphone", "iphonee", true},
+		{"iphone", "ipjone", true},
+		{"iphone", "xiphone", true},
+		{"iphone", "iphone", false},
+		{"iphone", "ipohne", false},
+		{"iphone", "iphonexx", false},
+		{"iphone", "phon", false},
+		{"iphone 12", "iphone 13", false},
+		{"iphone 12", "iphone 1", false},
+		{"iphone 12", "iphone 123", false},
+		{"iphone x", "iphone 1", false},
+		{"кошка", "кошкa", true},
+		{"кошка", "кошк", true},
+		{"кошка", "кошечка", false},
+		{"ёжик", "ежик", true},
+		{"日本語", "日本", true},
+		{"", "a", true},
+		{"", "1", false},
+	} {
+		if got := isTypo(c.a, c.b); got != c.want {
+			t.Errorf("isTypo(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+		if got := isTypo(c.b, c.a); got != c.want {
+			t.Errorf("isTypo(%q, %q) = %v, want %v", c.b, c.a, got, c.want)
+		}
+	}
+}
+
+func TestWordSignature(t *testing.T) {
+	for _, c := range []struct{ query, want string }{
+		{"case iphone 12", "12 case iphone"},
+		{"iphone  case\t12", "12 case iphone"},
+		{"чехол для iphone", "iphone для чехол"},
+		{"", ""},
+	} {
+		if got := wordSignature(c.query); got != c.want {
+			t.Errorf("wordSignature(%q) = %q, want %q", c.query, got, c.want)
+		}
+	}
+}
+
+func TestClusterQueries(t *testing.T) {
+	queries := []string{
+		"iphone case",   // 0
+		"case iphone",   // 1 reordering of 0
+		"iphone cse",    // 2 typo of 0
+		"iphone cs",     // 3 typo of 2 only
+		"iphone 12",     // 4
+		"iphone 13",     // 5 other digit
+		"kot",           // 6 short
+		"kit",           // 7 short typo
+		"чехол айфон",   // 8
+		"чехол айфона",  // 9 multi-byte insertion
+		"айфон чехол",   // 10 reordering of 8
+		"samsung cover", // 11
+	}
+	counts := []uint64{10, 3, 5, 1, 7, 6, 2, 2, 4, 1, 1, 1}
+	groups := clusterQueries(queries, counts, 5)
+	same := func(a, b int) bool { return groups[a] == groups[b] }
+
+	for _, c := range []struct {
+		a, b int
+		want bool
+	}{
+		{0, 1, true},
+		{0, 2, true},
+		// a chain of typos stops at the most counted query
+		{0, 3, false},
+		{2, 3, false},
+		{4, 5, false},
+		{6, 7, false},
+		{8, 9, true},
+		{8, 10, true},
+		{0, 11, false},
+	} {
+		if got := same(c.a, c.b); got != c.want {
+			t.Errorf("%q and %q grouped %v, want %v", queries[c.a], queries[c.b], got, c.want)
+		}
+	}
+
+	// the chain joins the more counted end when it is one typo away
+	counts[3] = 100
+	groups = clusterQueries(queries, counts, 5)
+	if groups[2] != groups[3] || groups[0] == groups[3] {
+		t.Errorf("groups %v, want iphone cse with iphone cs only", groups)
+	}
+}
+
+func TestRunCanonical(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		dimensions string
+		counts     map[string]uint64
+		want       string
+	}{
+		{"queries", "", map[string]uint64{"iphone case": 3, "iphone cse": 1},
+			"iphone case 3 iphone case 4,iphone cse 1 iphone case 4"},
+		// counts of a query are summed over its dimension values
+		{"dimensions", "country", map[string]uint64{"iphone case|ru": 3, "iphone case|us": 1, "iphone cse|ru": 5},
+			"iphone case 4 iphone cse 9,iphone cse 5 iphone cse 9"},
+		// only keys of a DB with dimensions are split
+		{"separator in a query", "", map[string]uint64{"iphone|case": 3, "iphone|cse": 1},
+			"iphone|case 3 iphone|case 4,iphone|cse 1 iphone|case 4"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			path := writeKeptDB(t, c.dimensions, c.counts)
+			output := filepath.Join(t.TempDir(), "canonical")
+			if err := runCanonical([]string{"--db", path, "--output", output}); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.ReplaceAll(readOutput(t, output), keySeparator, "|")
+			if got != c.want {
+				t.Errorf("canonical = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"query-counter/btree"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	metadataInput       = "input"
+	metadataInputOffset = "input-offset"
+	// the id of the cache snapshot holding counters left out of the DB
+	metadataCacheSnapshot = "cache-snapshot"
+)
+
+// startInput records the input of a run in the DB and returns the offset to
+// read it from: the offset of the last checkpoint of the same input with
+// resume and zero otherwise. Without periodic checkpoints the DB takes
+// counts of the input before the run ends, so until then no offset is
+// recorded.
+func startInput(db *btree.BTree, path string, resume bool, checkpoints bool) (int64, error) {
+	var offset int64
+	if resume {
+		if input := db.Metadata(metadataInput); input != path {
+			return 0, fmt.Errorf("DB is counted from input %q, got %q", input, path)
+		}
+		recorded := db.Metadata(metadataInputOffset)
+		if recorded == "" {
+			return 0, fmt.Errorf("DB has no checkpoint of input %q to resume from", path)
+		}
+		var err error
+		if offset, err = strconv.ParseInt(recorded, 10, 64); err != nil {
+			return 0, fmt.Errorf("bad input offset %q in DB", recorded)
+		}
+	}
+	if err := db.SetMetadata(metadataInput, path); err != nil {
+		return 0, err
+	}
+	recorded := ""
+	if checkpoints {
+		recorded = strconv.FormatInt(offset, 10)
+	}
+	if err := db.SetMetadata(metadataInputOffset, recorded); err != nil {
+		return 0, err
+	}
+	return offset, db.Sync()
+}
+
+// checkpoint writes the held counters and the input offset their counts
+// cover in one DB batch, so after a crash the DB has both or neither of them,
+// then syncs the users store. Merging a users sketch again does not change
+// it, so the store may run ahead of the DB.
+func (qw *QueryWorker) checkpoint(offset int64) error {
+	if err := qw.db.Begin(); err != nil {
+		return err
+	}
+	for key, value := range qw.held {
+		if err := qw.writeToDB(key, value); err != nil {
+			return err
+		}
+	}
+	if qw.held != nil {
+		qw.held = make(map[string]uint64)
+		atomic.StoreInt64(&qw.heldLen, 0)
+	}
+	if err := qw.db.SetMetadata(metadataInputOffset, strconv.FormatInt(offset, 10)); err != nil {
+		return err
+	}
+	// the counters of a loaded snapshot are written by now
+	if qw.snapshotLoaded {
+		if err := qw.db.SetMetadata(metadataCacheSnapshot, ""); err != nil {
+			return err
+		}
+		qw.snapshotLoaded = false
+	}
+	if qw.snapshotID != "" {
+		if err := qw.db.SetMetadata(metadataCacheSnapshot, qw.snapshotID); err != nil {
+			return err
+		}
+	}
+	if err := qw.db.Commit(); err != nil {
+		return err
+	}
+	if qw.store != nil {
+		return qw.store.Sync()
+	}
+	return nil
+}
+
+// writeFirstLoaded writes the first counter after a snapshot is loaded
+// without checkpoints. It may come from the snapshot, so the snapshot id is
+// cleared in the same batch and the DB never records the id together with a
+// counter of the snapshot.
+func (qw *QueryWorker) writeFirstLoaded(key string, value uint64) error {
+	if err := qw.db.Begin(); err != nil {
+		return err
+	}
+	if err := qw.writeToDB(key, value); err != nil {
+		return err
+	}
+	if err := qw.db.SetMetadata(metadataCacheSnapshot, ""); err != nil {
+		return err
+	}
+	qw.snapshotLoaded = false
+	return qw.db.Commit()
+}
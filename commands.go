@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"os"
+	"query-counter/btree"
+)
+
+// commands read a DB kept by a counting run, they are chosen by the first
+// argument.
+var commands = map[string]func(args []string) error{
+	"canonical": runCanonical,
+	"range":     runRange,
+	"rollup":    runRollup,
+	"series":    runSeries,
+	"stats":     runStats,
+	"trending":  runTrending,
+}
+
+// dbFlags are the flags needed to open a kept DB.
+type dbFlags struct {
+	path     *string
+	keyFile  *string
+	mmap     *bool
+	snapshot *string
+}
+
+func addDBFlags(fs *flag.FlagSet) *dbFlags {
+	return &dbFlags{
+		path:     fs.String("db", "./db", "Index file"),
+		keyFile:  fs.String("db-key-file", "", "File with hex encoded DB encryption key, "+dbKeyEnv+" is used when empty"),
+		mmap:     fs.Bool("db-mmap", false, "Access DB through a memory mapping"),
+		snapshot: fs.String("cache-snapshot", "", "Cache snapshot of the run that kept DB, when DB counts are partly in it"),
+	}
+}
+
+// open opens an existing DB and keeps it on Close. The counts of the cache
+// snapshot the DB records are added but not written to the file.
+func (f *dbFlags) open() (*btree.BTree, error) {
+	if _, err := os.Stat(*f.path); err != nil {
+		return nil, err
+	}
+	key, err := loadDBKey(*f.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	db, err := btree.NewBTree(*f.path, btree.Config{Key: key, Mmap: *f.mmap, Keep: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := addSnapshotCounts(db, *f.snapshot); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// writeOutput passes f a writer to the file at path or to the standard output
+// when path is empty.
+func writeOutput(path string, f func(w *bufio.Writer) error) error {
+	out := os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	w := bufio.NewWriter(out)
+	if err := f(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
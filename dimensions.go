@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"query-counter/btree"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	metadataDimensions = "dimensions"
+	metadataNgrams     = "ngrams"
+
+	// fieldBucket names the time bucket among the key fields.
+	fieldBucket = "bucket"
+)
+
+// keyFormat tells how a DB key is made of the input columns: the query, the
+// dimension columns in their order and the time bucket, joined by
+// keySeparator. The query is normalized first, with ngrams it is replaced by
+// its word n-grams.
+type keyFormat struct {
+	dimensions []string
+	bucket     *bucketSize
+	ngrams     int
+	normalize  *normalizer
+}
+
+// parseDimensions parses a comma separated list of dimension columns.
+func parseDimensions(spec string) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	dimensions := strings.Split(spec, ",")
+	seen := make(map[string]bool, len(dimensions))
+	for _, d := range dimensions {
+		switch d {
+		case "", columnQuery, columnWeight, columnUser, columnTime, fieldBucket:
+			return nil, fmt.Errorf("bad dimension name %q", d)
+		}
+		if seen[d] {
+			return nil, fmt.Errorf("dimension %q is given twice", d)
+		}
+		seen[d] = true
+	}
+	return dimensions, nil
+}
+
+// composite tells whether keys have more fields than the query.
+func (f *keyFormat) composite() bool {
+	return f != nil && (len(f.dimensions) > 0 || f.bucket != nil)
+}
+
+// fields returns the names of the key fields.
+func (f *keyFormat) fields() []string {
+	fields := append([]string{columnQuery}, f.dimensions...)
+	if f.bucket != nil {
+		fields = append(fields, fieldBucket)
+	}
+	return fields
+}
+
+// split returns the fields of a key made by key.
+func (f *keyFormat) split(key string) ([]string, error) {
+	parts := strings.Split(key, keySeparator)
+	if n := len(f.fields()); len(parts) != n {
+		return nil, fmt.Errorf("key %q does not have %d fields", key, n)
+	}
+	return parts, nil
+}
+
+// dimension returns the index of the named dimension or -1.
+func (f *keyFormat) dimension(name string) int {
+	return indexOf(f.dimensions, name)
+}
+
+// key joins the query, the dimension values and the bucket label into a key.
+func (f *keyFormat) key(query string, values []string, label string) (string, error) {
+	if strings.Contains(query, keySeparator) {
+		return "", fmt.Errorf("query contains the key separator %q", keySeparator)
+	}
+	var b strings.Builder
+	b.WriteString(query)
+	for i, v := range values {
+		if strings.Contains(v, keySeparator) {
+			return "", fmt.Errorf("%s contains the key separator %q", f.dimensions[i], keySeparator)
+		}
+		b.WriteString(keySeparator)
+		b.WriteString(v)
+	}
+	if f.bucket != nil {
+		b.WriteString(keySeparator)
+		b.WriteString(label)
+	}
+	return b.String(), nil
+}
+
+func (f *keyFormat) bucketName() string {
+	if f.bucket == nil {
+		return ""
+	}
+	return f.bucket.name
+}
+
+// checkKeyFormat makes sure the DB keys are always made the same way and
+// records the format in a new DB.
+func checkKeyFormat(db *btree.BTree, f *keyFormat) error {
+	if err := checkMetadata(db, metadataBucket, f.bucketName()); err != nil {
+		return err
+	}
+	if err := checkMetadata(db, metadataDimensions, strings.Join(f.dimensions, ",")); err != nil {
+		return err
+	}
+	ngrams := ""
+	if f.ngrams > 0 {
+		ngrams = strconv.Itoa(f.ngrams)
+	}
+	if err := checkMetadata(db, metadataNgrams, ngrams); err != nil {
+		return err
+	}
+	return checkMetadata(db, metadataNormalize, f.normalize.String())
+}
+
+// checkMetadata compares a DB setting with value, an empty DB takes value.
+func checkMetadata(db *btree.BTree, name string, value string) error {
+	stored := db.Metadata(name)
+	if stored == value {
+		return nil
+	}
+	if stored == "" {
+		empty, err := isEmpty(db)
+		if err != nil {
+			return err
+		}
+		if empty {
+			return db.SetMetadata(name, value)
+		}
+	}
+	return fmt.Errorf("DB is counted with %s %q, got %q", name, stored, value)
+}
+
+func isEmpty(db *btree.BTree) (bool, error) {
+	empty := true
+	err := db.Ascend(func(key string, value uint64) error {
+		empty = false
+		return btree.StopAscend
+	})
+	return empty, err
+}
+
+// keyFormatOf returns the key format recorded in the DB.
+func keyFormatOf(db *btree.BTree) (*keyFormat, error) {
+	f := &keyFormat{}
+	if name := db.Metadata(metadataBucket); name != "" {
+		bucket, err := parseBucketSize(name)
+		if err != nil {
+			return nil, err
+		}
+		f.bucket = bucket
+	}
+	if spec := db.Metadata(metadataDimensions); spec != "" {
+		dimensions, err := parseDimensions(spec)
+		if err != nil {
+			return nil, err
+		}
+		f.dimensions = dimensions
+	}
+	if n := db.Metadata(metadataNgrams); n != "" {
+		ngrams, err := strconv.Atoi(n)
+		if err != nil {
+			return nil, fmt.Errorf("bad ngrams %q in DB", n)
+		}
+		f.ngrams = ngrams
+	}
+	normalize, err := parseNormalization(db.Metadata(metadataNormalize))
+	if err != nil {
+		return nil, err
+	}
+	f.normalize = normalize
+	return f, nil
+}
+
+// runRollup writes the counts summed over the key fields left out of by.
+func runRollup(args []string) error {
+	fs := flag.NewFlagSet("rollup", flag.ExitOnError)
+	dbFlags := addDBFlags(fs)
+	var by = fs.String("by", columnQuery, "Comma separated key fields to keep: query, dimensions and bucket")
+	var outputPath = fs.String("output", "", "Result file, standard output when empty")
+	fs.Parse(args)
+
+	db, err := dbFlags.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	format, err := keyFormatOf(db)
+	if err != nil {
+		return err
+	}
+	fields := format.fields()
+
+	var keep []int
+	for _, name := range strings.Split(*by, ",") {
+		i := indexOf(fields, name)
+		if i < 0 {
+			return fmt.Errorf("unknown key field %q, DB keys have %s", name, strings.Join(fields, ","))
+		}
+		keep = append(keep, i)
+	}
+	// the leading fields of the key in their order group keys next to each
+	// other, others are summed in memory
+	leading := true
+	for i, k := range keep {
+		leading = leading && i == k
+	}
+
+	project := func(key string) (string, error) {
+		parts, err := format.split(key)
+		if err != nil {
+			return "", err
+		}
+		kept := make([]string, len(keep))
+		for i, k := range keep {
+			kept[i] = parts[k]
+		}
+		return strings.Join(kept, "\t"), nil
+	}
+	write := func(w *bufio.Writer, key string, total uint64) {
+		w.WriteString(key)
+		w.WriteByte('\t')
+		w.WriteString(strconv.FormatUint(total, 10))
+		w.WriteByte('\n')
+	}
+
+	return writeOutput(*outputPath, func(w *bufio.Writer) error {
+		if leading {
+			current, total, started := "", uint64(0), false
+			err := db.Ascend(func(key string, value uint64) error {
+				projected, err := project(key)
+				if err != nil {
+					return err
+				}
+				if started && projected != current {
+					write(w, current, total)
+					total = 0
+				}
+				current, started = projected, true
+				total += value
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if started {
+				write(w, current, total)
+			}
+			return nil
+		}
+
+		totals := make(map[string]uint64)
+		err := db.Ascend(func(key string, value uint64) error {
+			projected, err := project(key)
+			if err != nil {
+				return err
+			}
+			totals[projected] += value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(totals))
+		for k := range totals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			write(w, k, totals[k])
+		}
+		return nil
+	})
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
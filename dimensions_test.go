@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"query-counter/btree"
+	"strings"
+	"testing"
+)
+
+func TestParseDimensions(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want string
+	}{
+		{"", "[]"},
+		{"country", "[country]"},
+		{"country,device", "[country device]"},
+	} {
+		got, err := parseDimensions(c.spec)
+		if err != nil || fmt.Sprint(got) != c.want {
+			t.Errorf("parseDimensions(%q) = %v, %v, want %s", c.spec, got, err, c.want)
+		}
+	}
+	for _, spec := range []string{",", "country,", "country,country", "query", "weight", "user", "time", "bucket"} {
+		if got, err := parseDimensions(spec); err == nil {
+			t.Errorf("parseDimensions(%q) = %v", spec, got)
+		}
+	}
+}
+
+func TestKeySplit(t *testing.T) {
+	day, err := parseBucketSize("day")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		format *keyFormat
+		query  string
+		values []string
+		label  string
+	}{
+		{&keyFormat{}, "iphone 12", nil, ""},
+		{&keyFormat{dimensions: []string{"country"}}, "iphone 12", []string{"ru"}, ""},
+		// empty values keep their place
+		{&keyFormat{dimensions: []string{"country", "device"}}, "", []string{"", "mobile"}, ""},
+		{&keyFormat{dimensions: []string{"country"}, bucket: day}, "телефон", []string{"ru"}, "2024-01-02"},
+	} {
+		key, err := c.format.key(c.query, c.values, c.label)
+		if err != nil {
+			t.Errorf("key(%q, %q, %q): %v", c.query, c.values, c.label, err)
+			continue
+		}
+		want := append([]string{c.query}, c.values...)
+		if c.format.bucket != nil {
+			want = append(want, c.label)
+		}
+		fields, err := c.format.split(key)
+		if err != nil || fmt.Sprintf("%q", fields) != fmt.Sprintf("%q", want) {
+			t.Errorf("split(%q) = %q, %v, want %q", key, fields, err, want)
+		}
+	}
+
+	format := &keyFormat{dimensions: []string{"country"}}
+	if _, err := format.key("a"+keySeparator+"b", []string{"ru"}, ""); err == nil {
+		t.Error("query with the key separator accepted")
+	}
+	if _, err := format.key("a", []string{"r" + keySeparator + "u"}, ""); err == nil {
+		t.Error("value with the key separator accepted")
+	}
+	for _, key := range []string{"a", "a" + keySeparator + "ru" + keySeparator + "x"} {
+		if _, err := format.split(key); err == nil {
+			t.Errorf("split(%q) of a wrong field count succeeded", key)
+		}
+	}
+}
+
+// writeKeptDB writes a kept DB of counts with the given dimensions, fields of
+// the keys are separated by "|".
+func writeKeptDB(t *testing.T, dimensions string, counts map[string]uint64) string {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := btree.NewBTree(path, btree.Config{Keep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetMetadata(metadataDimensions, dimensions); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range counts {
+		if err := db.Insert(btree.NewPairs(strings.ReplaceAll(key, "|", keySeparator), value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readOutput reads a command output to one line, lines are separated by ","
+// and columns by " ".
+func readOutput(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := strings.ReplaceAll(strings.TrimSuffix(string(data), "\n"), "\n", ",")
+	return strings.ReplaceAll(out, "\t", " ")
+}
+
+func TestRollup(t *testing.T) {
+	path := writeKeptDB(t, "country,device", map[string]uint64{
+		"a|ru|mobile":  1,
+		"a|ru|desktop": 2,
+		"a|us|mobile":  4,
+		"b|ru|mobile":  8,
+		"b|us|desktop": 16,
+	})
+
+	for _, c := range []struct {
+		by   string
+		want string
+	}{
+		{"query", "a 7,b 24"},
+		{"query,country", "a ru 3,a us 4,b ru 8,b us 16"},
+		{"query,country,device", "a ru desktop 2,a ru mobile 1,a us mobile 4,b ru mobile 8,b us desktop 16"},
+		// fields out of the key order are summed in memory
+		{"country", "ru 11,us 20"},
+		{"device,query", "desktop a 2,desktop b 16,mobile a 5,mobile b 8"},
+	} {
+		output := filepath.Join(t.TempDir(), "rollup")
+		if err := runRollup([]string{"--db", path, "--by", c.by, "--output", output}); err != nil {
+			t.Errorf("rollup by %s: %v", c.by, err)
+			continue
+		}
+		if got := readOutput(t, output); got != c.want {
+			t.Errorf("rollup by %s = %s, want %s", c.by, got, c.want)
+		}
+	}
+	if err := runRollup([]string{"--db", path, "--by", "bucket", "--output", filepath.Join(t.TempDir(), "rollup")}); err == nil {
+		t.Error("rollup by a missing field succeeded")
+	}
+}
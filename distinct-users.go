@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"query-counter/btree"
+	"query-counter/lru"
+	"query-counter/sketch"
+)
+
+const (
+	sketchFileSuffix  = "-users"
+	sketchIndexSuffix = "-users.idx"
+
+	// sketchRecordHeader is the capacity and the length of a record.
+	sketchRecordHeader = 8
+)
+
+// distinctStore keeps a HyperLogLog sketch of users per key next to the DB.
+// Sketches are variable-size records in a file of their own and a second
+// B-tree maps every key to the offset of its record. A record is rewritten in
+// place while the sketch fits into it and moved to the end of the file when it
+// grows.
+type distinctStore struct {
+	index     *btree.BTree
+	file      *os.File
+	path      string
+	size      int64
+	precision uint8
+	keep      bool
+}
+
+func openDistinctStore(dbPath string, config btree.Config, precision uint8) (*distinctStore, error) {
+	if _, err := sketch.NewHyperLogLog(precision); err != nil {
+		return nil, err
+	}
+	path := dbPath + sketchFileSuffix
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	index, err := btree.NewBTree(dbPath+sketchIndexSuffix, config)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &distinctStore{
+		index:     index,
+		file:      file,
+		path:      path,
+		size:      info.Size(),
+		precision: precision,
+		keep:      config.Keep,
+	}, nil
+}
+
+// NewSketch returns an empty sketch of the store precision.
+func (s *distinctStore) NewSketch() *sketch.HyperLogLog {
+	h, _ := sketch.NewHyperLogLog(s.precision)
+	return h
+}
+
+func (s *distinctStore) read(offset int64) (*sketch.HyperLogLog, int, error) {
+	header := make([]byte, sketchRecordHeader)
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+	capacity := int(binary.LittleEndian.Uint32(header))
+	length := int(binary.LittleEndian.Uint32(header[4:]))
+	if length > capacity {
+		return nil, 0, errors.New("corrupted users sketch record")
+	}
+	data := make([]byte, length)
+	if _, err := s.file.ReadAt(data, offset+sketchRecordHeader); err != nil {
+		return nil, 0, err
+	}
+	h, err := sketch.HyperLogLogFromBytes(data)
+	return h, capacity, err
+}
+
+func (s *distinctStore) write(offset int64, capacity int, data []byte) error {
+	record := make([]byte, sketchRecordHeader+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(capacity))
+	binary.LittleEndian.PutUint32(record[4:], uint32(len(data)))
+	copy(record[sketchRecordHeader:], data)
+	_, err := s.file.WriteAt(record, offset)
+	return err
+}
+
+// append writes a new record with room for the sketch to double, a sketch
+// never grows past its dense form.
+func (s *distinctStore) append(data []byte) (int64, error) {
+	capacity := 2 * len(data)
+	if dense := 2 + 1<<s.precision; capacity > dense {
+		capacity = dense
+	}
+	if capacity < len(data) {
+		capacity = len(data)
+	}
+	offset := s.size
+	if err := s.write(offset, capacity, data); err != nil {
+		return 0, err
+	}
+	s.size += int64(sketchRecordHeader + capacity)
+	return offset, nil
+}
+
+// Merge adds the users of h to the stored sketch of key.
+func (s *distinctStore) Merge(key string, h *sketch.HyperLogLog) error {
+	offset, ok, err := s.index.Get(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// a record would be left without an index entry
+		if len(key) > s.index.MaxKeyLength() {
+			return btree.ErrKeyTooLong
+		}
+		offset, err := s.append(h.Bytes())
+		if err != nil {
+			return err
+		}
+		return s.index.Insert(btree.NewPairs(key, uint64(offset)))
+	}
+
+	stored, capacity, err := s.read(int64(offset))
+	if err != nil {
+		return err
+	}
+	if err := stored.Merge(h); err != nil {
+		return err
+	}
+	data := stored.Bytes()
+	if len(data) <= capacity {
+		return s.write(int64(offset), capacity, data)
+	}
+	moved, err := s.append(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.index.Update(key, uint64(moved))
+	return err
+}
+
+// Estimate returns the estimated number of distinct users of key.
+func (s *distinctStore) Estimate(key string) (uint64, error) {
+	offset, ok, err := s.index.Get(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	h, _, err := s.read(int64(offset))
+	if err != nil {
+		return 0, err
+	}
+	return h.Estimate(), nil
+}
+
+func (s *distinctStore) Sync() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
+func (s *distinctStore) Close() error {
+	if err := s.index.Close(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.keep {
+		return nil
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// InitUsers turns on counting distinct users per key: sketches of the users
+// are merged in a cache of cacheSize keys and into the store on eviction and
+// flush. It must be called before InitWorkers.
+func (qw *QueryWorker) InitUsers(store *distinctStore, cacheSize int) error {
+	users, err := lru.NewCache[string](cacheSize, func(old *sketch.HyperLogLog, delta *sketch.HyperLogLog) *sketch.HyperLogLog {
+		old.Merge(delta)
+		return old
+	})
+	if err != nil {
+		return err
+	}
+	users.OnEvict(func(key string, h *sketch.HyperLogLog) {
+		qw.results <- usersWrite{key: key, users: h}
+	})
+	qw.store = store
+	qw.users = users
+	return nil
+}
+
+func (qw *QueryWorker) addUser(key string, user string) {
+	h := qw.store.NewSketch()
+	h.Add(user)
+	qw.users.Merge(key, h)
+}
+
+// userSketches returns writes of the cached sketches, copies of them with
+// clone.
+func (qw *QueryWorker) userSketches(clone bool) []usersWrite {
+	var sketches []usersWrite
+	qw.users.Range(func(key string, h *sketch.HyperLogLog) {
+		if clone {
+			h = h.Clone()
+		}
+		sketches = append(sketches, usersWrite{key: key, users: h})
+	})
+	return sketches
+}
@@ -0,0 +1,145 @@
+package lru
+
+// arcPolicy is the Adaptive Replacement Cache. Resident keys live in t1
+// (seen once recently) and t2 (seen at least twice), evicted keys are
+// remembered in the ghost lists b1 and b2. A hit in a ghost list moves the
+// target size p of t1, so the policy adapts between recency and frequency.
+type arcPolicy struct {
+	size   int
+	p      int
+	nodes  map[string]*Node[string]
+	t1     list[string]
+	t2     list[string]
+	b1     list[string]
+	b2     list[string]
+	fromB2 bool
+	// added is the key of the last Add, the cache evicts after adding, while
+	// ARC replaces before: Victim looks at the lists without it
+	added *Node[string]
+}
+
+func newARCPolicy(size int) *arcPolicy {
+	return &arcPolicy{size: size, nodes: make(map[string]*Node[string])}
+}
+
+func (a *arcPolicy) Add(key string) {
+	a.fromB2 = false
+	node, ok := a.nodes[key]
+	switch {
+	case ok && node.list == &a.b1:
+		a.p = minInt(a.size, a.p+maxInt(a.b2.len/a.b1.len, 1))
+		a.b1.detach(node)
+		a.t2.attach(node)
+		a.added = node
+	case ok && node.list == &a.b2:
+		a.p = maxInt(0, a.p-maxInt(a.b1.len/a.b2.len, 1))
+		a.b2.detach(node)
+		a.t2.attach(node)
+		a.fromB2 = true
+		a.added = node
+	case ok:
+		a.Touch(key)
+	default:
+		node = &Node[string]{key: key}
+		a.nodes[key] = node
+		a.t1.attach(node)
+		a.added = node
+		for a.t1.len+a.b1.len > a.size && a.b1.len > 0 {
+			a.forget(&a.b1)
+		}
+		for a.t1.len+a.t2.len+a.b1.len+a.b2.len > 2*a.size && a.b2.len > 0 {
+			a.forget(&a.b2)
+		}
+	}
+}
+
+func (a *arcPolicy) Touch(key string) {
+	node, ok := a.nodes[key]
+	if !ok || (node.list != &a.t1 && node.list != &a.t2) {
+		return
+	}
+	node.list.detach(node)
+	a.t2.attach(node)
+	a.added = nil
+}
+
+func (a *arcPolicy) Victim() (string, bool) {
+	t1, t2 := a.t1.len, a.t2.len
+	if a.added != nil && a.added.list == &a.t1 {
+		t1--
+	}
+	if a.added != nil && a.added.list == &a.t2 {
+		t2--
+	}
+	from, ghost := &a.t2, &a.b2
+	if t1 > 0 && (t1 > a.p || (a.fromB2 && t1 == a.p) || t2 == 0) {
+		from, ghost = &a.t1, &a.b1
+	}
+	if from.len == 0 {
+		from, ghost = &a.t1, &a.b1
+	}
+	// the added key is at the head, it is only taken when it is alone
+	node := from.tail
+	if node == nil {
+		return "", false
+	}
+	if node == a.added {
+		a.added = nil
+	}
+	from.detach(node)
+	ghost.attach(node)
+	return node.key, true
+}
+
+// Remove drops a resident key without remembering it in a ghost list.
+func (a *arcPolicy) Remove(key string) {
+	node, ok := a.nodes[key]
+	if !ok || (node.list != &a.t1 && node.list != &a.t2) {
+		return
+	}
+	node.list.detach(node)
+	delete(a.nodes, key)
+	if node == a.added {
+		a.added = nil
+	}
+}
+
+// Resize forgets the added key, evictions after a resize do not follow an add.
+func (a *arcPolicy) Resize(size int) {
+	a.size = size
+	a.added = nil
+	a.p = minInt(a.p, size)
+	for a.b1.len > 0 && a.t1.len+a.b1.len > size {
+		a.forget(&a.b1)
+	}
+	for a.b2.len > 0 && a.t1.len+a.t2.len+a.b1.len+a.b2.len > 2*size {
+		a.forget(&a.b2)
+	}
+}
+
+// Order lists the keys seen once before the frequent ones, the ghost lists
+// are not a part of the cache content.
+func (a *arcPolicy) Order(f func(key string)) {
+	a.t1.order(f)
+	a.t2.order(f)
+}
+
+func (a *arcPolicy) forget(ghost *list[string]) {
+	node := ghost.tail
+	ghost.detach(node)
+	delete(a.nodes, node.key)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,125 @@
+package lru
+
+import (
+	"errors"
+	"sync"
+)
+
+// Cache is a least recently used cache of any values. Updates of a cached key
+// are combined with its value by the merge function, so the cache can
+// aggregate counters, sets or histograms before they are written elsewhere.
+// Keys are evicted by the same recency policy as the lru policy of LRU.
+type Cache[K comparable, V any] struct {
+	data    map[K]V
+	policy  *recencyPolicy[K]
+	maxSize int
+	merge   func(old V, delta V) V
+	onEvict func(key K, value V)
+	lock    sync.Mutex
+}
+
+func NewCache[K comparable, V any](maxSize int, merge func(old V, delta V) V) (*Cache[K, V], error) {
+	if maxSize <= 0 {
+		return nil, errors.New("lru max-size must provide a positive size")
+	}
+	if merge == nil {
+		return nil, errors.New("lru merge function must be provided")
+	}
+
+	return &Cache[K, V]{
+		data:    make(map[K]V, maxSize),
+		policy:  newRecencyPolicy[K](),
+		maxSize: maxSize,
+		merge:   merge,
+	}, nil
+}
+
+func (c *Cache[K, V]) removeOld() (K, V, bool) {
+	var value V
+	if len(c.data) <= c.maxSize {
+		var key K
+		return key, value, false
+	}
+	key, ok := c.policy.Victim()
+	if ok {
+		value = c.data[key]
+		delete(c.data, key)
+	}
+	return key, value, ok
+}
+
+// OnEvict sets a hook called with every evicted entry. It must be set before
+// the cache is used.
+func (c *Cache[K, V]) OnEvict(f func(key K, value V)) {
+	c.onEvict = f
+}
+
+// Merge combines delta with the cached value of key, or caches delta as is
+// for a new key. An entry evicted to make room is passed to the OnEvict hook.
+func (c *Cache[K, V]) Merge(key K, delta V) {
+	c.lock.Lock()
+	if old, ok := c.data[key]; ok {
+		c.data[key] = c.merge(old, delta)
+		c.policy.Touch(key)
+	} else {
+		c.data[key] = delta
+		c.policy.Add(key)
+	}
+	evictedKey, evicted, ok := c.removeOld()
+	c.lock.Unlock()
+
+	if ok && c.onEvict != nil {
+		c.onEvict(evictedKey, evicted)
+	}
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	value, ok := c.data[key]
+	if ok {
+		c.policy.Touch(key)
+	}
+	return value, ok
+}
+
+// Peek returns the value of key without counting it as an access.
+func (c *Cache[K, V]) Peek(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	value, ok := c.data[key]
+	return value, ok
+}
+
+// Remove drops key from the cache and returns its value. The OnEvict hook
+// is not called.
+func (c *Cache[K, V]) Remove(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	value, ok := c.data[key]
+	if ok {
+		c.policy.Remove(key)
+		delete(c.data, key)
+	}
+	return value, ok
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.data)
+}
+
+// Range calls f for every entry from the least to the most recently used.
+func (c *Cache[K, V]) Range(f func(key K, value V)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.policy.Order(func(key K) {
+		f(key, c.data[key])
+	})
+}
@@ -0,0 +1,50 @@
+package lru
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCacheMergeAndEvict(t *testing.T) {
+	cache, err := NewCache[int](3, func(old []string, delta []string) []string {
+		return append(old, delta...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evicted []string
+	cache.OnEvict(func(key int, value []string) {
+		evicted = append(evicted, fmt.Sprint(key, value))
+	})
+
+	cache.Merge(1, []string{"a"})
+	cache.Merge(2, []string{"b"})
+	cache.Merge(3, []string{"c"})
+	cache.Merge(1, []string{"d"})
+	cache.Get(2)
+	// 3 is the least recently used now
+	cache.Merge(4, []string{"e"})
+	if fmt.Sprint(evicted) != "[3 [c]]" {
+		t.Errorf("evicted %v, want [3 [c]]", evicted)
+	}
+
+	var order []string
+	cache.Range(func(key int, value []string) {
+		order = append(order, fmt.Sprint(key, value))
+	})
+	if fmt.Sprint(order) != "[1 [a d] 2 [b] 4 [e]]" {
+		t.Errorf("Range %v, want [1 [a d] 2 [b] 4 [e]]", order)
+	}
+
+	if value, ok := cache.Remove(1); !ok || len(value) != 2 {
+		t.Errorf("Remove = %v, %v", value, ok)
+	}
+	if _, ok := cache.Peek(1); ok || cache.Len() != 2 {
+		t.Errorf("removed key is cached, Len %d", cache.Len())
+	}
+	cache.Merge(5, nil)
+	cache.Merge(6, nil)
+	if fmt.Sprint(evicted) != "[3 [c] 2 [b]]" {
+		t.Errorf("evicted %v, want [3 [c] 2 [b]]", evicted)
+	}
+}
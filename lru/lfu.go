@@ -0,0 +1,123 @@
+package lru
+
+import (
+	"container/heap"
+	"sort"
+)
+
+type lfuEntry struct {
+	key   string
+	count uint64
+	tick  uint64
+	index int
+}
+
+// lfuHeap orders entries by access count, the older access wins a tie.
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// lfuPolicy evicts the least frequently used key. Counts start over when a
+// key comes back after eviction. The key just added is not a victim while
+// others are cached, otherwise a new key would be evicted right away once
+// every cached key was used twice; it is still the likely next victim
+// unless it is used again.
+type lfuPolicy struct {
+	entries map[string]*lfuEntry
+	heap    lfuHeap
+	tick    uint64
+	added   *lfuEntry
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) Add(key string) {
+	p.tick++
+	e := &lfuEntry{key: key, count: 1, tick: p.tick}
+	p.entries[key] = e
+	heap.Push(&p.heap, e)
+	p.added = e
+}
+
+func (p *lfuPolicy) Touch(key string) {
+	if e, ok := p.entries[key]; ok {
+		p.tick++
+		e.count++
+		e.tick = p.tick
+		heap.Fix(&p.heap, e.index)
+		p.added = nil
+	}
+}
+
+func (p *lfuPolicy) Victim() (string, bool) {
+	if len(p.heap) == 0 {
+		return "", false
+	}
+	// the least used key after the added one is one of the root children
+	i := 0
+	if p.heap[0] == p.added && len(p.heap) > 1 {
+		i = 1
+		if len(p.heap) > 2 && p.heap.Less(2, 1) {
+			i = 2
+		}
+	}
+	e := heap.Remove(&p.heap, i).(*lfuEntry)
+	delete(p.entries, e.key)
+	if e == p.added {
+		p.added = nil
+	}
+	return e.key, true
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	if e, ok := p.entries[key]; ok {
+		heap.Remove(&p.heap, e.index)
+		delete(p.entries, key)
+		if e == p.added {
+			p.added = nil
+		}
+	}
+}
+
+// Resize forgets the added key, evictions after a resize do not follow an add.
+func (p *lfuPolicy) Resize(size int) {
+	p.added = nil
+}
+
+func (p *lfuPolicy) Order(f func(key string)) {
+	entries := make(lfuHeap, len(p.heap))
+	copy(entries, p.heap)
+	sort.Slice(entries, entries.Less)
+	for _, e := range entries {
+		f(e.key)
+	}
+}
@@ -2,118 +2,311 @@ package lru
 
 import (
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
-type Node struct {
-	prev  *Node
-	next  *Node
-	key   string
-	value uint64
+const (
+	// EntryOverhead is the estimated memory taken by a cached entry besides
+	// its key: the map slot, the policy node and its index, and the dirty set
+	// slot while it is changed.
+	EntryOverhead = 128
+	// averageKeySize is only used to size policies of memory bounded caches.
+	averageKeySize = 32
+)
+
+// Entry is a key with its counter.
+type Entry struct {
+	Key   string
+	Value uint64
+}
+
+// Stats are counters of cache activity since it was created.
+type Stats struct {
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Inserts    uint64 `json:"inserts"`
+	Evictions  uint64 `json:"evictions"`
+	EvictedSum uint64 `json:"evicted_sum"`
+}
+
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		Hits:       s.Hits + o.Hits,
+		Misses:     s.Misses + o.Misses,
+		Inserts:    s.Inserts + o.Inserts,
+		Evictions:  s.Evictions + o.Evictions,
+		EvictedSum: s.EvictedSum + o.EvictedSum,
+	}
+}
+
+// HitRatio returns the share of lookups that found the key in the cache.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("hits=%d misses=%d hit-ratio=%.4f inserts=%d evictions=%d evicted-sum=%d",
+		s.Hits, s.Misses, s.HitRatio(), s.Inserts, s.Evictions, s.EvictedSum)
 }
 
 type LRU struct {
-	data    map[string]*Node
-	head    *Node
-	tail    *Node
-	maxSize int
-	len     int
-	lock    sync.Mutex
+	data     map[string]uint64
+	policy   Policy
+	maxSize  int
+	maxBytes int64
+	bytes    int64
+	onEvict  func(key string, value uint64)
+	stats    Stats
+	// dirtyKeys are the keys with a non-zero counter, so Flush does not scan
+	// the whole cache. dirty is their number, it is only changed under the
+	// lock but may be read without it.
+	dirtyKeys map[string]struct{}
+	dirty     int64
+	lock      sync.Mutex
 }
 
 func NewLRU(maxSize int) (*LRU, error) {
+	return NewCacheWithPolicy(maxSize, PolicyLRU)
+}
+
+// NewCacheWithPolicy creates a cache that evicts keys chosen by the named
+// policy instead of the least recently used ones.
+func NewCacheWithPolicy(maxSize int, policy string) (*LRU, error) {
 	if maxSize <= 0 {
 		return nil, errors.New("lru max-size must provide a positive size")
 	}
+	p, err := NewPolicy(policy, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LRU{
+		data:      make(map[string]uint64, maxSize),
+		policy:    p,
+		maxSize:   maxSize,
+		dirtyKeys: make(map[string]struct{}),
+	}, nil
+}
+
+// NewMemoryBoundedCache creates a cache limited by the estimated memory of
+// its entries, keys and EntryOverhead, rather than by their number.
+func NewMemoryBoundedCache(maxBytes int64, policy string) (*LRU, error) {
+	if maxBytes < EntryOverhead {
+		return nil, fmt.Errorf("lru max-bytes must be at least %d", EntryOverhead)
+	}
+	p, err := NewPolicy(policy, policySize(maxBytes))
+	if err != nil {
+		return nil, err
+	}
 
 	return &LRU{
-		data:    make(map[string]*Node, maxSize),
-		head:    nil,
-		tail:    nil,
-		maxSize: maxSize,
-		len:     0,
+		data:      make(map[string]uint64),
+		policy:    p,
+		maxBytes:  maxBytes,
+		dirtyKeys: make(map[string]struct{}),
 	}, nil
 }
 
-func (lru *LRU) removeOld() *Node {
-	if lru.len > lru.maxSize {
-		old := lru.tail
-		newTail := old.prev
-		newTail.next = nil
-		lru.tail = newTail
+func policySize(maxBytes int64) int {
+	if size := int(maxBytes / (EntryOverhead + averageKeySize)); size > 0 {
+		return size
+	}
+	return 1
+}
+
+func entrySize(key string) int64 {
+	return int64(len(key)) + EntryOverhead
+}
 
-		old.prev = nil
-		old.next = nil
-		delete(lru.data, old.key)
-		lru.len -= 1
-		return old
+func (lru *LRU) overflown() bool {
+	if lru.maxBytes > 0 {
+		return lru.bytes > lru.maxBytes
 	}
-	return nil
+	return len(lru.data) > lru.maxSize
 }
 
-func (lru *LRU) attach(node *Node) {
-	lru.len += 1
-	if lru.head != nil {
-		node.next = lru.head
-		lru.head.prev = node
-		lru.head = node
-	} else {
-		lru.head = node
-		lru.tail = node
+func (lru *LRU) removeOld() []Entry {
+	var evicted []Entry
+	for lru.overflown() {
+		key, ok := lru.policy.Victim()
+		if !ok {
+			break
+		}
+		evicted = append(evicted, Entry{Key: key, Value: lru.data[key]})
+		lru.delete(key)
+	}
+	return evicted
+}
+
+func (lru *LRU) countEvicted(evicted []Entry) {
+	for _, e := range evicted {
+		lru.stats.Evictions++
+		lru.stats.EvictedSum += e.Value
+	}
+}
+
+func (lru *LRU) delete(key string) {
+	if lru.data[key] != 0 {
+		delete(lru.dirtyKeys, key)
+		atomic.AddInt64(&lru.dirty, -1)
+	}
+	delete(lru.data, key)
+	lru.bytes -= entrySize(key)
+}
+
+func (lru *LRU) markDirty(key string) {
+	lru.dirtyKeys[key] = struct{}{}
+	atomic.AddInt64(&lru.dirty, 1)
+}
+
+// notify passes evicted entries to the hook. It is called after the lock is
+// released, so the hook may block or use the cache.
+func (lru *LRU) notify(evicted []Entry) {
+	if lru.onEvict == nil {
+		return
+	}
+	for _, e := range evicted {
+		lru.onEvict(e.Key, e.Value)
 	}
 }
 
-func (lru *LRU) detach(node *Node) {
-	lru.len -= 1
-	if node.prev != nil {
-		node.prev.next = node.next
+// OnEvict sets a hook called with every entry evicted by the policy. It must
+// be set before the cache is used.
+func (lru *LRU) OnEvict(f func(key string, value uint64)) {
+	lru.onEvict = f
+}
+
+// PushOrIncrement adds value to the counter of key. Entries evicted to make
+// room for it are passed to the OnEvict hook.
+func (lru *LRU) PushOrIncrement(key string, value uint64) {
+	lru.lock.Lock()
+	if old, ok := lru.data[key]; ok {
+		if old == 0 && value != 0 {
+			lru.markDirty(key)
+		}
+		lru.data[key] = old + value
+		lru.policy.Touch(key)
+		lru.stats.Hits++
 	} else {
-		lru.head = node.next
+		if value != 0 {
+			lru.markDirty(key)
+		}
+		lru.data[key] = value
+		lru.bytes += entrySize(key)
+		lru.policy.Add(key)
+		lru.stats.Misses++
+		lru.stats.Inserts++
 	}
+	evicted := lru.removeOld()
+	lru.countEvicted(evicted)
+	lru.lock.Unlock()
+
+	lru.notify(evicted)
+}
 
-	if node.next != nil {
-		node.next.prev = node.prev
+func (lru *LRU) Get(key string) (uint64, bool) {
+	lru.lock.Lock()
+	defer lru.lock.Unlock()
+
+	if value, ok := lru.data[key]; ok {
+		lru.policy.Touch(key)
+		lru.stats.Hits++
+		return value, true
 	} else {
-		lru.tail = node.prev
+		lru.stats.Misses++
+		return 0, false
 	}
+}
+
+// Peek returns the counter of key without counting it as an access.
+func (lru *LRU) Peek(key string) (uint64, bool) {
+	lru.lock.Lock()
+	defer lru.lock.Unlock()
 
-	node.prev = nil
-	node.next = nil
+	value, ok := lru.data[key]
+	return value, ok
 }
 
-func (lru *LRU) PushOrIncrement(key string, value uint64) (uint64, bool) {
+// Remove drops key from the cache and returns its counter. The OnEvict hook
+// is not called.
+func (lru *LRU) Remove(key string) (uint64, bool) {
 	lru.lock.Lock()
 	defer lru.lock.Unlock()
 
-	node, ok := lru.data[key]
+	value, ok := lru.data[key]
 	if ok {
-		node.value += 1
-		lru.detach(node)
-		lru.attach(node)
-	} else {
-		node = &Node{key: key, value: value}
-		lru.data[key] = node
-		lru.attach(node)
+		lru.policy.Remove(key)
+		lru.delete(key)
 	}
+	return value, ok
+}
+
+// Flush returns the entries with non-zero counters and resets the counters,
+// keeping the keys cached. Used as a write-back cache the counters are deltas
+// not yet written to the store.
+func (lru *LRU) Flush() []Entry {
+	lru.lock.Lock()
+	defer lru.lock.Unlock()
 
-	if old := lru.removeOld(); old != nil {
-		return old.value, true
+	entries := make([]Entry, 0, len(lru.dirtyKeys))
+	for k := range lru.dirtyKeys {
+		entries = append(entries, Entry{Key: k, Value: lru.data[k]})
+		lru.data[k] = 0
 	}
+	lru.dirtyKeys = make(map[string]struct{})
+	atomic.StoreInt64(&lru.dirty, 0)
+	return entries
+}
 
-	return 0, false
+// Dirty returns the number of entries changed since the last flush.
+func (lru *LRU) Dirty() int {
+	return int(atomic.LoadInt64(&lru.dirty))
 }
 
-func (lru *LRU) Get(key string) (uint64, bool) {
+func (lru *LRU) Stats() Stats {
+	lru.lock.Lock()
+	defer lru.lock.Unlock()
+
+	return lru.stats
+}
+
+func (lru *LRU) Len() int {
 	lru.lock.Lock()
 	defer lru.lock.Unlock()
 
-	if node, ok := lru.data[key]; ok {
-		lru.detach(node)
-		lru.attach(node)
-		return node.value, true
+	return len(lru.data)
+}
+
+// Resize changes the limit of the cache, the number of entries or the memory
+// budget for a memory bounded one. Entries over the new limit are evicted.
+func (lru *LRU) Resize(limit int64) error {
+	lru.lock.Lock()
+	if lru.maxBytes > 0 {
+		if limit < EntryOverhead {
+			lru.lock.Unlock()
+			return fmt.Errorf("lru max-bytes must be at least %d", EntryOverhead)
+		}
+		lru.maxBytes = limit
+		lru.policy.Resize(policySize(limit))
 	} else {
-		return 0, false
+		if limit <= 0 {
+			lru.lock.Unlock()
+			return errors.New("lru max-size must provide a positive size")
+		}
+		lru.maxSize = int(limit)
+		lru.policy.Resize(lru.maxSize)
 	}
+	evicted := lru.removeOld()
+	lru.countEvicted(evicted)
+	lru.lock.Unlock()
+
+	lru.notify(evicted)
+	return nil
 }
 
 func (lru *LRU) Range(f func(key string, value uint64)) {
@@ -121,6 +314,6 @@ func (lru *LRU) Range(f func(key string, value uint64)) {
 	defer lru.lock.Unlock()
 
 	for k, v := range lru.data {
-		f(k, v.value)
+		f(k, v)
 	}
 }
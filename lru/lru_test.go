@@ -0,0 +1,116 @@
+package lru
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBoundedEviction(t *testing.T) {
+	if _, err := NewMemoryBoundedCache(EntryOverhead-1, PolicyLRU); err == nil {
+		t.Error("budget below one entry accepted")
+	}
+
+	// room for three entries of ten byte keys
+	cache, err := NewMemoryBoundedCache(3*(EntryOverhead+10), PolicyLRU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evicted []string
+	cache.OnEvict(func(key string, value uint64) {
+		evicted = append(evicted, key)
+	})
+	for i := 0; i < 4; i++ {
+		cache.PushOrIncrement(fmt.Sprintf("key-%06d", i), 1)
+	}
+	if fmt.Sprint(evicted) != "[key-000000]" || cache.Len() != 3 {
+		t.Errorf("evicted %v, %d cached, want key-000000 evicted and 3 cached", evicted, cache.Len())
+	}
+
+	// a key as long as two entries takes the room of two more
+	evicted = nil
+	cache.PushOrIncrement(strings.Repeat("k", EntryOverhead+20), 1)
+	if fmt.Sprint(evicted) != "[key-000001 key-000002]" || cache.Len() != 2 {
+		t.Errorf("evicted %v, %d cached", evicted, cache.Len())
+	}
+
+	// shrinking the budget evicts down to it, the long key no longer fits
+	evicted = nil
+	if err := cache.Resize(2*EntryOverhead + 20); err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 1 || evicted[0] != "key-000003" || cache.Len() != 1 {
+		t.Errorf("evicted %v, %d cached", evicted, cache.Len())
+	}
+	if err := cache.Resize(EntryOverhead - 1); err == nil {
+		t.Error("resize below one entry accepted")
+	}
+}
+
+func TestMemoryBoundedSharded(t *testing.T) {
+	const shards, perShard = 4, 5
+	cache, err := NewMemoryBoundedShardedLRU(shards*perShard*(EntryOverhead+10), shards, PolicyLRU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.PushOrIncrement(fmt.Sprintf("key-%06d", i), 1)
+	}
+	if n := cache.Len(); n != shards*perShard {
+		t.Errorf("%d cached, want %d", n, shards*perShard)
+	}
+	for i := 0; i < shards; i++ {
+		if n := cache.shards[i].Len(); n != perShard {
+			t.Errorf("shard %d holds %d, want %d", i, n, perShard)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	cache, err := NewLRU(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.PushOrIncrement("a", 5)
+	cache.PushOrIncrement("a", 1)
+	cache.PushOrIncrement("b", 2)
+	cache.Get("b")
+	cache.Get("x")
+	cache.Peek("a")
+	cache.PushOrIncrement("c", 3)
+	want := Stats{Hits: 2, Misses: 4, Inserts: 3, Evictions: 1, EvictedSum: 6}
+	if stats := cache.Stats(); stats != want {
+		t.Errorf("stats %v, want %v", stats, want)
+	}
+	if ratio := cache.Stats().HitRatio(); ratio != 2.0/6 {
+		t.Errorf("hit ratio %v, want 1/3", ratio)
+	}
+}
+
+func TestShardedStats(t *testing.T) {
+	const shards = 4
+	cache, err := NewShardedLRU(20, shards, PolicyLRU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		cache.PushOrIncrement(fmt.Sprintf("key-%03d", i), 2)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Get(fmt.Sprintf("key-%03d", i))
+	}
+	want := Stats{Hits: 20, Misses: 180, Inserts: 100, Evictions: 80, EvictedSum: 160}
+	if stats := cache.Stats(); stats != want {
+		t.Errorf("stats %v, want %v", stats, want)
+	}
+	var sum Stats
+	for _, shard := range cache.shards {
+		if shard.Stats().Inserts == 0 {
+			t.Error("a shard got no keys")
+		}
+		sum = sum.add(shard.Stats())
+	}
+	if sum != want {
+		t.Errorf("shards sum up to %v, want %v", sum, want)
+	}
+}
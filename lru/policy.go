@@ -0,0 +1,143 @@
+package lru
+
+import "fmt"
+
+const (
+	PolicyLRU     = "lru"
+	PolicyLFU     = "lfu"
+	PolicyARC     = "arc"
+	PolicyTinyLFU = "tinylfu"
+)
+
+// Policy decides which key leaves a full cache. The cache calls it under its
+// own lock, so implementations need no locking.
+type Policy interface {
+	// Add records a key that was just put into the cache.
+	Add(key string)
+	// Touch records an access to a key already in the cache.
+	Touch(key string)
+	// Victim picks a key to evict and forgets it.
+	Victim() (string, bool)
+	// Remove forgets a key removed from the cache by the caller.
+	Remove(key string)
+	// Resize tells the policy the new capacity of the cache in entries.
+	Resize(size int)
+	// Order calls f for every key from the next to be evicted to the last.
+	Order(f func(key string))
+}
+
+// NewPolicy creates the named policy for a cache of size entries.
+func NewPolicy(name string, size int) (Policy, error) {
+	switch name {
+	case PolicyLRU:
+		return newRecencyPolicy[string](), nil
+	case PolicyLFU:
+		return newLFUPolicy(), nil
+	case PolicyARC:
+		return newARCPolicy(size), nil
+	case PolicyTinyLFU:
+		return newTinyLFUPolicy(size), nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %q", name)
+}
+
+type Node[K comparable] struct {
+	prev *Node[K]
+	next *Node[K]
+	list *list[K]
+	key  K
+}
+
+// list is a doubly linked list of keys, the most recent one at the head.
+type list[K comparable] struct {
+	head *Node[K]
+	tail *Node[K]
+	len  int
+}
+
+// order calls f from the tail to the head.
+func (l *list[K]) order(f func(key K)) {
+	for node := l.tail; node != nil; node = node.prev {
+		f(node.key)
+	}
+}
+
+func (l *list[K]) attach(node *Node[K]) {
+	l.len += 1
+	node.list = l
+	if l.head != nil {
+		node.next = l.head
+		l.head.prev = node
+		l.head = node
+	} else {
+		l.head = node
+		l.tail = node
+	}
+}
+
+func (l *list[K]) detach(node *Node[K]) {
+	l.len -= 1
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		l.head = node.next
+	}
+
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		l.tail = node.prev
+	}
+
+	node.prev = nil
+	node.next = nil
+	node.list = nil
+}
+
+// recencyPolicy evicts the least recently used key. With string keys it is
+// the lru Policy, Cache uses it for keys of any type.
+type recencyPolicy[K comparable] struct {
+	nodes map[K]*Node[K]
+	list  list[K]
+}
+
+func newRecencyPolicy[K comparable]() *recencyPolicy[K] {
+	return &recencyPolicy[K]{nodes: make(map[K]*Node[K])}
+}
+
+func (p *recencyPolicy[K]) Add(key K) {
+	node := &Node[K]{key: key}
+	p.nodes[key] = node
+	p.list.attach(node)
+}
+
+func (p *recencyPolicy[K]) Touch(key K) {
+	if node, ok := p.nodes[key]; ok {
+		p.list.detach(node)
+		p.list.attach(node)
+	}
+}
+
+func (p *recencyPolicy[K]) Victim() (K, bool) {
+	old := p.list.tail
+	if old == nil {
+		var zero K
+		return zero, false
+	}
+	p.list.detach(old)
+	delete(p.nodes, old.key)
+	return old.key, true
+}
+
+func (p *recencyPolicy[K]) Remove(key K) {
+	if node, ok := p.nodes[key]; ok {
+		p.list.detach(node)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *recencyPolicy[K]) Resize(size int) {}
+
+func (p *recencyPolicy[K]) Order(f func(key K)) {
+	p.list.order(f)
+}
@@ -0,0 +1,175 @@
+package lru
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// runOps runs space separated operations on cache: "k" pushes key k, "?k"
+// gets it and "!k" peeks at it. It returns the evicted keys.
+func runOps(cache *LRU, ops string) []string {
+	var evicted []string
+	cache.OnEvict(func(key string, value uint64) {
+		evicted = append(evicted, key)
+	})
+	for _, op := range strings.Fields(ops) {
+		if key := strings.TrimPrefix(op, "?"); key != op {
+			cache.Get(key)
+		} else if key := strings.TrimPrefix(op, "!"); key != op {
+			cache.Peek(key)
+		} else {
+			cache.PushOrIncrement(key, 1)
+		}
+	}
+	return evicted
+}
+
+func TestPolicyEvictionOrder(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		policy string
+		size   int
+		ops    string
+		want   string
+	}{
+		{"lru", PolicyLRU, 3, "a b c ?a d e", "[b c]"},
+		{"lfu least used", PolicyLFU, 3, "a b c ?a ?a ?b d e", "[c d]"},
+		// a new key gets in when all cached keys are used twice
+		{"lfu all frequent", PolicyLFU, 2, "a ?a b ?b c", "[a]"},
+		{"arc recency", PolicyARC, 2, "a b c", "[a]"},
+		// with p zero a new key in t1 replaces from t2, not itself
+		{"arc new key", PolicyARC, 2, "a ?a b ?b c", "[a]"},
+		{"arc ghost hits", PolicyARC, 2, "a b c a ?c d a", "[a b a d]"},
+		// b leaves the window and loses the tie with a in the main segment
+		{"tinylfu window", PolicyTinyLFU, 2, "a b c", "[b]"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cache, err := NewCacheWithPolicy(c.size, c.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(runOps(cache, c.ops)); got != c.want {
+				t.Errorf("%s evicted %s, want %s", c.ops, got, c.want)
+			}
+		})
+	}
+}
+
+func TestPolicyResizeOrder(t *testing.T) {
+	for _, c := range []struct {
+		policy string
+		ops    string
+		want   string
+	}{
+		{PolicyLRU, "a b c d ?a", "[b c]"},
+		{PolicyLFU, "a b c d ?a ?a ?b ?d ?d ?d", "[c b]"},
+		// keys seen once go first while p is zero
+		{PolicyARC, "a b ?a ?b c d", "[c d]"},
+		// a leaves the protected segment of one key and is evicted after c
+		{PolicyTinyLFU, "a b c d ?a ?a ?b", "[c a]"},
+	} {
+		t.Run(c.policy, func(t *testing.T) {
+			cache, err := NewCacheWithPolicy(4, c.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if evicted := runOps(cache, c.ops); len(evicted) != 0 {
+				t.Fatalf("evicted %v before the resize", evicted)
+			}
+			var evicted []string
+			cache.OnEvict(func(key string, value uint64) {
+				evicted = append(evicted, key)
+			})
+			if err := cache.Resize(2); err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(evicted); got != c.want || cache.Len() != 2 {
+				t.Errorf("resize evicted %s leaving %d, want %s leaving 2", got, cache.Len(), c.want)
+			}
+		})
+	}
+}
+
+func TestPeekDoesNotPromote(t *testing.T) {
+	for _, c := range []struct {
+		policy string
+		ops    string
+		want   string
+	}{
+		{PolicyLRU, "a b !a c", "[a]"},
+		{PolicyLFU, "a b !a c", "[a]"},
+		{PolicyARC, "a b !a c", "[a]"},
+		// b leaving the window loses the tie with a
+		{PolicyTinyLFU, "a b !b c", "[b]"},
+	} {
+		t.Run(c.policy, func(t *testing.T) {
+			for _, peek := range []bool{true, false} {
+				ops := c.ops
+				if !peek {
+					ops = strings.ReplaceAll(ops, "!", "?")
+				}
+				cache, err := NewCacheWithPolicy(2, c.policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// a get of the same key must change the victim
+				if got := fmt.Sprint(runOps(cache, ops)); (got == c.want) != peek {
+					t.Errorf("%s evicted %s", ops, got)
+				}
+			}
+		})
+	}
+}
+
+func TestARCAdaptsToGhostHits(t *testing.T) {
+	cache, err := NewCacheWithPolicy(2, PolicyARC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arc := cache.policy.(*arcPolicy)
+	for _, c := range []struct {
+		ops  string
+		p    int
+		want string
+	}{
+		{"a b c", 0, "[a]"},
+		// a hit in b1 makes room for recent keys
+		{"a", 1, "[b]"},
+		{"?c d", 1, "[a]"},
+		// a hit in b2 makes room for frequent keys
+		{"a", 0, "[d]"},
+	} {
+		if got := fmt.Sprint(runOps(cache, c.ops)); got != c.want || arc.p != c.p {
+			t.Errorf("%s evicted %s with p %d, want %s with p %d", c.ops, got, arc.p, c.want, c.p)
+		}
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	// one key window and nine keys in the main segment
+	cache, err := NewCacheWithPolicy(10, PolicyTinyLFU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := "k0 k1 k2 k3 k4 k5 k6 k7 k8 k9"
+	for i := 0; i < 3; i++ {
+		ops += " ?k0 ?k1 ?k2 ?k3 ?k4 ?k5 ?k6 ?k7 ?k8"
+	}
+	if evicted := runOps(cache, ops); len(evicted) != 0 {
+		t.Fatalf("evicted %v before the cache is full", evicted)
+	}
+
+	// k9 leaving the window is used less than the main victim
+	if evicted := fmt.Sprint(runOps(cache, "new")); evicted != "[k9]" {
+		t.Errorf("evicted %s, want the rejected k9", evicted)
+	}
+	// new is used more than the main victim and takes its place
+	evicted := runOps(cache, "?new ?new ?new ?new ?new x")
+	if len(evicted) != 1 || !strings.HasPrefix(evicted[0], "k") {
+		t.Errorf("evicted %v, want a key of the main segment", evicted)
+	}
+	if _, ok := cache.Peek("new"); !ok {
+		t.Error("admitted key is not cached")
+	}
+}
@@ -0,0 +1,132 @@
+package lru
+
+import "errors"
+
+// ShardedLRU splits keys between independent LRU segments by key hash, so
+// concurrent callers only contend when their keys land in the same segment.
+// Every segment runs its own instance of the eviction policy.
+type ShardedLRU struct {
+	shards []*LRU
+}
+
+func NewShardedLRU(maxSize int, shards int, policy string) (*ShardedLRU, error) {
+	if shards <= 0 {
+		return nil, errors.New("lru shards must provide a positive count")
+	}
+	if maxSize < shards {
+		return nil, errors.New("lru max-size must be at least the number of shards")
+	}
+
+	shardSize := (maxSize + shards - 1) / shards
+	return newShardedLRU(shards, func() (*LRU, error) {
+		return NewCacheWithPolicy(shardSize, policy)
+	})
+}
+
+// NewMemoryBoundedShardedLRU splits the memory budget evenly between shards.
+func NewMemoryBoundedShardedLRU(maxBytes int64, shards int, policy string) (*ShardedLRU, error) {
+	if shards <= 0 {
+		return nil, errors.New("lru shards must provide a positive count")
+	}
+
+	shardBytes := maxBytes / int64(shards)
+	return newShardedLRU(shards, func() (*LRU, error) {
+		return NewMemoryBoundedCache(shardBytes, policy)
+	})
+}
+
+func newShardedLRU(shards int, newShard func() (*LRU, error)) (*ShardedLRU, error) {
+	s := &ShardedLRU{shards: make([]*LRU, shards)}
+	for i := range s.shards {
+		shard, err := newShard()
+		if err != nil {
+			return nil, err
+		}
+		s.shards[i] = shard
+	}
+	return s, nil
+}
+
+// shard picks the segment with FNV-1a hash of the key.
+func (s *ShardedLRU) shard(key string) *LRU {
+	var h uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+func (s *ShardedLRU) OnEvict(f func(key string, value uint64)) {
+	for _, shard := range s.shards {
+		shard.OnEvict(f)
+	}
+}
+
+func (s *ShardedLRU) PushOrIncrement(key string, value uint64) {
+	s.shard(key).PushOrIncrement(key, value)
+}
+
+func (s *ShardedLRU) Peek(key string) (uint64, bool) {
+	return s.shard(key).Peek(key)
+}
+
+func (s *ShardedLRU) Remove(key string) (uint64, bool) {
+	return s.shard(key).Remove(key)
+}
+
+func (s *ShardedLRU) Len() int {
+	length := 0
+	for _, shard := range s.shards {
+		length += shard.Len()
+	}
+	return length
+}
+
+func (s *ShardedLRU) Flush() []Entry {
+	var entries []Entry
+	for _, shard := range s.shards {
+		entries = append(entries, shard.Flush()...)
+	}
+	return entries
+}
+
+func (s *ShardedLRU) Dirty() int {
+	dirty := 0
+	for _, shard := range s.shards {
+		dirty += shard.Dirty()
+	}
+	return dirty
+}
+
+func (s *ShardedLRU) Stats() Stats {
+	var stats Stats
+	for _, shard := range s.shards {
+		stats = stats.add(shard.Stats())
+	}
+	return stats
+}
+
+// Resize splits the new limit evenly between shards.
+func (s *ShardedLRU) Resize(limit int64) error {
+	shardLimit := limit / int64(len(s.shards))
+	if limit%int64(len(s.shards)) != 0 && s.shards[0].maxBytes == 0 {
+		shardLimit++
+	}
+	for _, shard := range s.shards {
+		if err := shard.Resize(shardLimit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShardedLRU) Get(key string) (uint64, bool) {
+	return s.shard(key).Get(key)
+}
+
+func (s *ShardedLRU) Range(f func(key string, value uint64)) {
+	for _, shard := range s.shards {
+		shard.Range(f)
+	}
+}
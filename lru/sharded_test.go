@@ -0,0 +1,174 @@
+package lru
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// counterCache is the surface shared by LRU and ShardedLRU.
+type counterCache interface {
+	OnEvict(f func(key string, value uint64))
+	PushOrIncrement(key string, value uint64)
+	Get(key string) (uint64, bool)
+	Peek(key string) (uint64, bool)
+	Remove(key string) (uint64, bool)
+	Len() int
+	Flush() []Entry
+	Dirty() int
+	Range(f func(key string, value uint64))
+}
+
+func newTestCaches(t *testing.T, size int) map[string]counterCache {
+	single, err := NewLRU(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharded, err := NewShardedLRU(size, 4, PolicyLRU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]counterCache{"LRU": single, "ShardedLRU": sharded}
+}
+
+func TestCacheCounters(t *testing.T) {
+	for name, cache := range newTestCaches(t, 100) {
+		t.Run(name, func(t *testing.T) {
+			want := make(map[string]uint64)
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i % 50)
+				cache.PushOrIncrement(key, uint64(i%3))
+				want[key] += uint64(i % 3)
+			}
+			if cache.Len() != 50 {
+				t.Errorf("Len %d, want 50", cache.Len())
+			}
+			for key, value := range want {
+				if got, ok := cache.Get(key); !ok || got != value {
+					t.Errorf("Get(%q) = %d, %v, want %d", key, got, ok, value)
+				}
+			}
+			if _, ok := cache.Peek("missing"); ok {
+				t.Error("Peek found a missing key")
+			}
+
+			if value, ok := cache.Remove("7"); !ok || value != want["7"] {
+				t.Errorf("Remove = %d, %v, want %d", value, ok, want["7"])
+			}
+			delete(want, "7")
+			if _, ok := cache.Peek("7"); ok {
+				t.Error("removed key is cached")
+			}
+
+			ranged := make(map[string]uint64)
+			cache.Range(func(key string, value uint64) { ranged[key] = value })
+			if fmt.Sprint(ranged) != fmt.Sprint(want) {
+				t.Errorf("Range %v, want %v", ranged, want)
+			}
+
+			dirty := 0
+			for _, v := range want {
+				if v != 0 {
+					dirty++
+				}
+			}
+			if cache.Dirty() != dirty {
+				t.Errorf("Dirty %d, want %d", cache.Dirty(), dirty)
+			}
+			flushed := make(map[string]uint64)
+			for _, e := range cache.Flush() {
+				flushed[e.Key] = e.Value
+			}
+			if len(flushed) != dirty || cache.Dirty() != 0 || cache.Len() != 49 {
+				t.Errorf("Flush returned %d entries, %d dirty and %d cached after", len(flushed), cache.Dirty(), cache.Len())
+			}
+			for key, value := range flushed {
+				if want[key] != value {
+					t.Errorf("flushed %q=%d, want %d", key, value, want[key])
+				}
+			}
+		})
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	for name, cache := range newTestCaches(t, 100) {
+		t.Run(name, func(t *testing.T) {
+			var lock sync.Mutex
+			var evicted uint64
+			cache.OnEvict(func(key string, value uint64) {
+				lock.Lock()
+				evicted += value
+				lock.Unlock()
+			})
+
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(seed int64) {
+					defer wg.Done()
+					r := rand.New(rand.NewSource(seed))
+					for i := 0; i < 10000; i++ {
+						cache.PushOrIncrement(strconv.Itoa(r.Intn(1000)), 1)
+					}
+				}(int64(g))
+			}
+			wg.Wait()
+
+			if cache.Len() > 100 {
+				t.Errorf("Len %d over the limit", cache.Len())
+			}
+			// every increment is either cached or evicted
+			var cached uint64
+			cache.Range(func(key string, value uint64) { cached += value })
+			if cached+evicted != 80000 {
+				t.Errorf("cached %d and evicted %d, want 80000 in total", cached, evicted)
+			}
+		})
+	}
+}
+
+// benchmarkCache runs increments and lookups of Zipf distributed keys from
+// all GOMAXPROCS goroutines, run with -cpu 1,2,4,8 to see the scaling.
+func benchmarkCache(b *testing.B, cache counterCache) {
+	keys := make([]string, 1<<16)
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 1<<20)
+	for i := range keys {
+		keys[i] = "query " + strconv.FormatUint(zipf.Uint64(), 10)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(keys))
+		for pb.Next() {
+			key := keys[i&(len(keys)-1)]
+			if i%4 == 0 {
+				cache.Get(key)
+			} else {
+				cache.PushOrIncrement(key, 1)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkLRU(b *testing.B) {
+	cache, err := NewLRU(10000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkCache(b, cache)
+}
+
+func BenchmarkShardedLRU(b *testing.B) {
+	for _, shards := range []int{4, 16, 64} {
+		b.Run(strconv.Itoa(shards), func(b *testing.B) {
+			cache, err := NewShardedLRU(10000, shards, PolicyLRU)
+			if err != nil {
+				b.Fatal(err)
+			}
+			benchmarkCache(b, cache)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package lru
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const snapshotMagic = "QCLRU1"
+
+// MaxSnapshotKey is the longest key a snapshot may hold, so a damaged length
+// does not make LoadFrom allocate an arbitrary amount of memory.
+const MaxSnapshotKey = 1 << 16
+
+// SaveTo writes the cached entries from the coldest to the hottest, so
+// LoadFrom restores them in the same order of eviction. Only the order is
+// saved: LFU, ARC and W-TinyLFU rebuild their state from it, as if the keys
+// were seen once each in that order.
+func (lru *LRU) SaveTo(w io.Writer) error {
+	lru.lock.Lock()
+	defer lru.lock.Unlock()
+
+	sw := newSnapshotWriter(w)
+	var err error
+	lru.policy.Order(func(key string) {
+		if err == nil {
+			err = sw.write(Entry{Key: key, Value: lru.data[key]})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sw.flush()
+}
+
+// LoadFrom adds entries written by SaveTo to the cache. Counters of keys
+// already cached are summed up. Loading is not counted in Stats, evicted
+// entries are passed to the OnEvict hook.
+func (lru *LRU) LoadFrom(r io.Reader) error {
+	return ReadSnapshot(r, lru.load)
+}
+
+func (lru *LRU) load(key string, value uint64) {
+	lru.lock.Lock()
+	old, ok := lru.data[key]
+	if old == 0 && value != 0 {
+		lru.markDirty(key)
+	}
+	lru.data[key] = old + value
+	if ok {
+		lru.policy.Touch(key)
+	} else {
+		lru.bytes += entrySize(key)
+		lru.policy.Add(key)
+	}
+	evicted := lru.removeOld()
+	lru.lock.Unlock()
+
+	lru.notify(evicted)
+}
+
+// SaveTo writes the shards one after another, the order is kept per shard.
+func (s *ShardedLRU) SaveTo(w io.Writer) error {
+	for _, shard := range s.shards {
+		shard.lock.Lock()
+	}
+	defer func() {
+		for _, shard := range s.shards {
+			shard.lock.Unlock()
+		}
+	}()
+
+	sw := newSnapshotWriter(w)
+	var err error
+	for _, shard := range s.shards {
+		shard.policy.Order(func(key string) {
+			if err == nil {
+				err = sw.write(Entry{Key: key, Value: shard.data[key]})
+			}
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return sw.flush()
+}
+
+func (s *ShardedLRU) LoadFrom(r io.Reader) error {
+	return ReadSnapshot(r, func(key string, value uint64) {
+		s.shard(key).load(key, value)
+	})
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	buf []byte
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), buf: make([]byte, binary.MaxVarintLen64)}
+	_, sw.err = sw.w.WriteString(snapshotMagic)
+	return sw
+}
+
+// write stores an entry as key length, key and value, lengths and values as
+// uvarints.
+func (sw *snapshotWriter) write(e Entry) error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if len(e.Key) > MaxSnapshotKey {
+		sw.err = fmt.Errorf("cache snapshot: key length %d is over %d", len(e.Key), MaxSnapshotKey)
+		return sw.err
+	}
+	n := binary.PutUvarint(sw.buf, uint64(len(e.Key)))
+	if _, sw.err = sw.w.Write(sw.buf[:n]); sw.err != nil {
+		return sw.err
+	}
+	if _, sw.err = sw.w.WriteString(e.Key); sw.err != nil {
+		return sw.err
+	}
+	n = binary.PutUvarint(sw.buf, e.Value)
+	_, sw.err = sw.w.Write(sw.buf[:n])
+	return sw.err
+}
+
+func (sw *snapshotWriter) flush() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+// ReadSnapshot passes f the entries of a snapshot written by SaveTo in their
+// order.
+func ReadSnapshot(r io.Reader, f func(key string, value uint64)) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return errors.New("not a cache snapshot")
+	}
+	for {
+		keyLen, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cache snapshot: %v", err)
+		}
+		if keyLen > MaxSnapshotKey {
+			return fmt.Errorf("cache snapshot: key length %d is over %d", keyLen, MaxSnapshotKey)
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(br, key); err != nil {
+			return fmt.Errorf("cache snapshot: %v", err)
+		}
+		value, err := binary.ReadUvarint(br)
+		if err != nil {
+			return fmt.Errorf("cache snapshot: %v", err)
+		}
+		f(string(key), value)
+	}
+}
@@ -0,0 +1,116 @@
+package lru
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, policy := range []string{PolicyLRU, PolicyLFU} {
+		t.Run(policy, func(t *testing.T) {
+			cache, err := NewCacheWithPolicy(3, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache.PushOrIncrement("a", 1)
+			cache.PushOrIncrement("b", 2)
+			cache.PushOrIncrement("c", 3)
+			cache.Get("a")
+
+			var buf bytes.Buffer
+			if err := cache.SaveTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			restored, err := NewCacheWithPolicy(3, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := restored.LoadFrom(&buf); err != nil {
+				t.Fatal(err)
+			}
+			var entries []string
+			restored.Range(func(key string, value uint64) {
+				entries = append(entries, fmt.Sprint(key, value))
+			})
+			if len(entries) != 3 {
+				t.Fatalf("restored %v", entries)
+			}
+			for key, want := range map[string]uint64{"a": 1, "b": 2, "c": 3} {
+				if value, ok := restored.Get(key); !ok || value != want {
+					t.Errorf("Get(%s) = %d, %v, want %d", key, value, ok, want)
+				}
+			}
+		})
+	}
+
+	// the restored LRU evicts in the saved order
+	cache, _ := NewCacheWithPolicy(3, PolicyLRU)
+	for _, key := range []string{"a", "b", "c", "a"} {
+		cache.PushOrIncrement(key, 1)
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := NewCacheWithPolicy(3, PolicyLRU)
+	if err := restored.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var evicted []string
+	restored.OnEvict(func(key string, value uint64) {
+		evicted = append(evicted, key)
+	})
+	restored.PushOrIncrement("d", 1)
+	restored.PushOrIncrement("e", 1)
+	if fmt.Sprint(evicted) != "[b c]" {
+		t.Errorf("evicted %v, want [b c]", evicted)
+	}
+
+	if err := restored.LoadFrom(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Error("LoadFrom of garbage succeeded")
+	}
+}
+
+func TestSnapshotDamaged(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"huge key length":  append([]byte(snapshotMagic), 0xff, 0xff, 0xff, 0xff, 0x0f),
+		"key over the max": append([]byte(snapshotMagic), 0x81, 0x80, 0x04),
+		"truncated key":    append([]byte(snapshotMagic), 5, 'a', 'b'),
+		"missing value":    append([]byte(snapshotMagic), 1, 'a'),
+	} {
+		cache, _ := NewLRU(3)
+		if err := cache.LoadFrom(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: LoadFrom succeeded", name)
+		}
+	}
+
+	cache, _ := NewLRU(3)
+	cache.PushOrIncrement(strings.Repeat("k", MaxSnapshotKey+1), 1)
+	if err := cache.SaveTo(io.Discard); err == nil {
+		t.Error("SaveTo of a key over the max succeeded")
+	}
+}
+
+func TestSnapshotLoadKeepsStats(t *testing.T) {
+	cache, _ := NewLRU(3)
+	for _, key := range []string{"a", "b", "c", "a"} {
+		cache.PushOrIncrement(key, 1)
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := NewShardedLRU(6, 2, PolicyLRU)
+	if err := restored.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if stats := restored.Stats(); stats != (Stats{}) {
+		t.Errorf("loading counted %v", stats)
+	}
+	if dirty := restored.Dirty(); dirty != 3 {
+		t.Errorf("%d dirty after loading, want 3", dirty)
+	}
+}
@@ -0,0 +1,185 @@
+package lru
+
+const (
+	sketchDepth     = 4
+	sketchMaxCount  = 15
+	sketchResetMult = 10
+)
+
+// frequencySketch is a count-min sketch of small saturating counters. All
+// counters are halved after a number of increments, so old popularity fades.
+type frequencySketch struct {
+	counters [sketchDepth][]uint8
+	mask     uint64
+	added    int
+	resetAt  int
+}
+
+func newFrequencySketch(size int) *frequencySketch {
+	width := 16
+	for width < size {
+		width <<= 1
+	}
+	s := &frequencySketch{mask: uint64(width - 1), resetAt: sketchResetMult * size}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *frequencySketch) hash(key string) (uint64, uint64) {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h, h>>32 | 1
+}
+
+func (s *frequencySketch) increment(key string) {
+	h1, h2 := s.hash(key)
+	for i := range s.counters {
+		index := (h1 + uint64(i)*h2) & s.mask
+		if s.counters[i][index] < sketchMaxCount {
+			s.counters[i][index]++
+		}
+	}
+	s.added++
+	if s.added >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *frequencySketch) estimate(key string) uint8 {
+	h1, h2 := s.hash(key)
+	min := uint8(sketchMaxCount)
+	for i := range s.counters {
+		if c := s.counters[i][(h1+uint64(i)*h2)&s.mask]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *frequencySketch) reset() {
+	for i := range s.counters {
+		for j := range s.counters[i] {
+			s.counters[i][j] >>= 1
+		}
+	}
+	s.added /= 2
+}
+
+// tinyLFUPolicy is W-TinyLFU: new keys enter a small LRU window, keys leaving
+// the window compete with the eviction candidate of the main segmented LRU,
+// and the one with the lower estimated frequency is evicted.
+type tinyLFUPolicy struct {
+	nodes        map[string]*Node[string]
+	sketch       *frequencySketch
+	window       list[string]
+	probation    list[string]
+	protected    list[string]
+	windowSize   int
+	mainSize     int
+	protectedMax int
+}
+
+func newTinyLFUPolicy(size int) *tinyLFUPolicy {
+	t := &tinyLFUPolicy{
+		nodes:  make(map[string]*Node[string]),
+		sketch: newFrequencySketch(size),
+	}
+	t.Resize(size)
+	return t
+}
+
+// Resize changes the segment sizes, the frequency sketch keeps its width.
+func (t *tinyLFUPolicy) Resize(size int) {
+	t.windowSize = maxInt(1, size/100)
+	t.mainSize = maxInt(1, size-t.windowSize)
+	t.protectedMax = maxInt(1, t.mainSize*8/10)
+	for t.protected.len > t.protectedMax {
+		demoted := t.protected.tail
+		t.protected.detach(demoted)
+		t.probation.attach(demoted)
+	}
+}
+
+func (t *tinyLFUPolicy) Remove(key string) {
+	if node, ok := t.nodes[key]; ok {
+		t.evict(node)
+	}
+}
+
+func (t *tinyLFUPolicy) Add(key string) {
+	t.sketch.increment(key)
+	node := &Node[string]{key: key}
+	t.nodes[key] = node
+	t.window.attach(node)
+	// while the main segment has room the window simply spills into it
+	if t.window.len > t.windowSize && t.probation.len+t.protected.len < t.mainSize {
+		candidate := t.window.tail
+		t.window.detach(candidate)
+		t.probation.attach(candidate)
+	}
+}
+
+func (t *tinyLFUPolicy) Touch(key string) {
+	node, ok := t.nodes[key]
+	if !ok {
+		return
+	}
+	t.sketch.increment(key)
+	switch node.list {
+	case &t.probation:
+		t.probation.detach(node)
+		t.protected.attach(node)
+		if t.protected.len > t.protectedMax {
+			demoted := t.protected.tail
+			t.protected.detach(demoted)
+			t.probation.attach(demoted)
+		}
+	default:
+		list := node.list
+		list.detach(node)
+		list.attach(node)
+	}
+}
+
+func (t *tinyLFUPolicy) Victim() (string, bool) {
+	if t.window.len > t.windowSize {
+		candidate := t.window.tail
+		victim := t.probation.tail
+		if victim == nil {
+			victim = t.protected.tail
+		}
+		if victim == nil {
+			return t.evict(candidate), true
+		}
+		if t.sketch.estimate(candidate.key) <= t.sketch.estimate(victim.key) {
+			return t.evict(candidate), true
+		}
+		key := t.evict(victim)
+		t.window.detach(candidate)
+		t.probation.attach(candidate)
+		return key, true
+	}
+	for _, l := range []*list[string]{&t.probation, &t.protected, &t.window} {
+		if l.tail != nil {
+			return t.evict(l.tail), true
+		}
+	}
+	return "", false
+}
+
+func (t *tinyLFUPolicy) Order(f func(key string)) {
+	t.probation.order(f)
+	t.window.order(f)
+	t.protected.order(f)
+}
+
+func (t *tinyLFUPolicy) evict(node *Node[string]) string {
+	node.list.detach(node)
+	delete(t.nodes, node.key)
+	return node.key
+}
@@ -1,41 +1,220 @@
 package main
 
 import (
+	"encoding/hex"
+	"expvar"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
+	"os"
 	"query-counter/btree"
 	"query-counter/lru"
+	"strconv"
+	"strings"
+)
+
+const (
+	dbKeyEnv = "QUERY_COUNTER_DB_KEY"
+
+	modeExact  = "exact"
+	modeApprox = "approx"
+	modeTopK   = "topk"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	var inputPath = flag.String("input", "./queries.txt", "Parser file")
+	var inputColumns = flag.String("columns", columnQuery, "Tab separated input columns: query and optional weight, user and time")
+	var dimensionsSpec = flag.String("dimensions", "", "Comma separated input columns to count queries by, e.g. country,device")
+	var normalization = flag.String("normalize", "", "Comma separated query normalizations: url, nfkc, lower, punct, space and trim")
+	var ngramSize = flag.Int("ngrams", 0, "Count word n-grams of queries from one word up to this length instead of whole queries")
+	var bucketName = flag.String("bucket", "hour", "Time bucket of counts with a time column: hour or day")
 	var outputPath = flag.String("output", "./result.txt", "Result file")
+	var mode = flag.String("mode", modeExact, "Counting mode: exact with cache and DB, approx with count-min sketch or topk with space-saving")
+	var cmsEpsilon = flag.Float64("cms-epsilon", 0.0001, "Approx mode: overcount bound as a share of all counts")
+	var cmsDelta = flag.Float64("cms-delta", 0.001, "Approx mode: probability to exceed the overcount bound")
+	var topK = flag.Int("top-k", 1000, "Approx and topk modes: number of keys to keep and export")
 	var cacheSize = flag.Int("cache-size", 10000, "Cache size")
+	var cacheMemory = flag.String("cache-memory", "", "Cache memory budget, e.g. 2GiB, replaces cache-size when set")
+	var cacheShards = flag.Int("cache-shards", 1, "Number of independent cache segments")
+	var cachePolicy = flag.String("cache-policy", lru.PolicyLRU, "Cache eviction policy: lru, lfu, arc or tinylfu")
 	var db = flag.String("db", "./db", "Index file")
+	var keepDB = flag.Bool("keep-db", false, "Keep DB file after the run to resume counting later")
+	var resume = flag.Bool("resume", false, "Read the input from the offset of the last DB checkpoint, e.g. after a crash")
+	var cacheSnapshot = flag.String("cache-snapshot", "", "File to save cache to on exit and load it from on start")
+	var warmUp = flag.Int("warm-up", 0, "Preload cache with this many keys of the highest counts from DB")
+	var blockSize = flag.Int("block-size", 0, "DB block size in bytes, "+strconv.Itoa(btree.DefaultBlockSize)+" for a new DB when zero")
+	var fanOut = flag.Int("fan-out", 0, "Max pairs per DB block, "+strconv.Itoa(btree.DefaultMaxLeafSize)+" for a new DB when zero")
+	var compress = flag.Bool("db-compress", false, "Compress DB blocks")
+	var prefixKeys = flag.Bool("db-prefix-keys", false, "Store DB keys with prefix compression")
+	var mmap = flag.Bool("db-mmap", false, "Access DB through a memory mapping")
+	var flushInterval = flag.Duration("flush-interval", 0, "Write changed cache counters to DB this often, e.g. 30s")
+	var flushDirty = flag.Int("flush-dirty", 0, "Write changed cache counters to DB once this many keys are changed")
+	var metricsAddr = flag.String("metrics-addr", "", "Address to serve metrics on at /debug/vars, e.g. :8080")
+	var hllPrecision = flag.Uint("hll-precision", 12, "Precision of distinct users sketches, 2^p one byte registers")
+	var usersCacheSize = flag.Int("users-cache-size", 10000, "Number of keys to keep distinct users sketches in memory for")
+	var keyFile = flag.String("db-key-file", "", "File with hex encoded DB encryption key, "+dbKeyEnv+" is used when empty")
 	flag.Parse()
 
-	bTree, err := btree.NewBTree(*db)
+	dimensions, err := parseDimensions(*dimensionsSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	columns, err := parseColumns(*inputColumns, dimensions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *ngramSize < 0 {
+		log.Fatalf("bad ngrams %d", *ngramSize)
+	}
+	normalize, err := parseNormalization(*normalization)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch *mode {
+	case modeExact:
+	case modeApprox, modeTopK:
+		for _, c := range []string{columnUser, columnTime} {
+			if hasColumn(columns, c) {
+				log.Fatalf("input column %q is only supported in %s mode", c, modeExact)
+			}
+		}
+		if len(dimensions) > 0 {
+			log.Fatalf("dimensions are only supported in %s mode", modeExact)
+		}
+		counter, err := newApproxCounter(*mode, *cmsEpsilon, *cmsDelta, *topK)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runApprox(counter, *inputPath, columns, &keyFormat{ngrams: *ngramSize, normalize: normalize}, *outputPath); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Query counter done")
+		return
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+
+	key, err := loadDBKey(*keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *cacheSnapshot != "" && !*keepDB {
+		log.Fatal("cache snapshot needs --keep-db, it holds counts left out of the DB")
+	}
+	if *cacheSnapshot != "" && key != nil {
+		log.Fatal("cache snapshot is not encrypted, it can not be used with an encrypted DB")
+	}
+
+	dbConfig := btree.Config{
+		BlockSize:   *blockSize,
+		MaxLeafSize: *fanOut,
+		Compress:    *compress,
+		PrefixKeys:  *prefixKeys,
+		Key:         key,
+		Mmap:        *mmap,
+		Keep:        *keepDB,
+	}
+	bTree, err := btree.NewBTree(*db, dbConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer bTree.Close()
 
-	cache, err := lru.NewLRU(*cacheSize)
+	format := &keyFormat{dimensions: dimensions, ngrams: *ngramSize, normalize: normalize}
+	if hasColumn(columns, columnTime) {
+		if format.bucket, err = parseBucketSize(*bucketName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := checkKeyFormat(bTree, format); err != nil {
+		log.Fatal(err)
+	}
+
+	cacheBytes, err := parseByteSize(*cacheMemory)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cache, err := newCache(*cacheSize, cacheBytes, *cacheShards, *cachePolicy)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *metricsAddr != "" {
+		expvar.Publish("cache", expvar.Func(func() interface{} {
+			return cache.Stats()
+		}))
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
 	worker, err := NewQueryWorker(bTree, 10, cache)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	queryReader, err := NewQueryReader(*inputPath, worker)
+	if hasColumn(columns, columnUser) {
+		if *hllPrecision > 255 {
+			log.Fatalf("bad hll-precision %d", *hllPrecision)
+		}
+		store, err := openDistinctStore(*db, dbConfig, uint8(*hllPrecision))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		if err := worker.InitUsers(store, *usersCacheSize); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if format.composite() {
+		worker.SplitKeys()
+	}
+
+	queryReader, err := NewQueryReader(*inputPath, columns, format, worker)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer queryReader.Close()
-
+	// more keys than the cache holds would only be evicted again
+	warmUpLimit := *cacheSize
+	if cacheBytes > 0 {
+		warmUpLimit = int(cacheBytes / lru.EntryOverhead)
+	}
+	if *warmUp > warmUpLimit {
+		log.Printf("Warming up the cache with %d keys it can hold", warmUpLimit)
+		*warmUp = warmUpLimit
+	}
+	if err := worker.WarmUp(*warmUp); err != nil {
+		log.Fatal(err)
+	}
+	if err := worker.LoadSnapshot(*cacheSnapshot); err != nil {
+		log.Fatal(err)
+	}
+	offset, err := startInput(bTree, *inputPath, *resume, *flushInterval > 0 || *flushDirty > 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if offset > 0 {
+		if err := queryReader.StartAt(offset); err != nil {
+			log.Fatal(err)
+		}
+	}
+	worker.InitFlusher(*flushInterval, *flushDirty)
 	worker.InitWorkers()
 
 	go worker.ResultProcessing()
@@ -44,9 +223,76 @@ func main() {
 		log.Fatal(err)
 	}
 	worker.Wait()
+	log.Printf("Cache stats: %v", cache.Stats())
+	if writes, hits := worker.Skipped(); writes > 0 {
+		log.Printf("Skipped %d writes of too long keys with %d hits", writes, hits)
+	}
 
 	if err := worker.ExportToFile(*outputPath); err != nil {
 		log.Fatal(err)
 	}
 	log.Println("Query counter done")
 }
+
+// newCache creates a cache bounded by memory when bytes is positive and by
+// the number of entries otherwise.
+func newCache(size int, bytes int64, shards int, policy string) (counterCache, error) {
+	switch {
+	case bytes > 0 && shards == 1:
+		return lru.NewMemoryBoundedCache(bytes, policy)
+	case bytes > 0:
+		return lru.NewMemoryBoundedShardedLRU(bytes, shards, policy)
+	case shards == 1:
+		return lru.NewCacheWithPolicy(size, policy)
+	}
+	return lru.NewShardedLRU(size, shards, policy)
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes like 512MB or 2GiB, an empty string is zero.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	number, unit := s, int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	// NaN fails both comparisons
+	if err != nil || !(value >= 0) || !(value*float64(unit) < math.MaxInt64) {
+		return 0, fmt.Errorf("bad byte size %q", s)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// loadDBKey reads a hex encoded key from path or from the environment when
+// path is empty. No key means the database is not encrypted.
+func loadDBKey(path string) ([]byte, error) {
+	text := os.Getenv(dbKeyEnv)
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(text)
+}
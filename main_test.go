@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"1024", 1024},
+		{"100B", 100},
+		{"2KiB", 2048},
+		{"512 MiB", 512 << 20},
+		{"2GiB", 2 << 30},
+		{"1TiB", 1 << 40},
+		{"1.5KB", 1500},
+		{"512MB", 512e6},
+		{"2GB", 2e9},
+		{"3TB", 3e12},
+		{" 64MB ", 64e6},
+		{"0.5KiB", 512},
+		{"8000000TB", 8e18},
+	} {
+		if got, err := parseByteSize(c.s); err != nil || got != c.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", c.s, got, err, c.want)
+		}
+	}
+	for _, s := range []string{"-1", "-1MB", "MB", "ten", "1XB", "1e30TB", "10000000TB", "NaN", "InfKB", "1 000"} {
+		if got, err := parseByteSize(s); err == nil {
+			t.Errorf("parseByteSize(%q) = %d", s, got)
+		}
+	}
+}
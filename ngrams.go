@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// words splits text at every rune that is not a letter, a digit or a mark,
+// so punctuation and spaces of any script separate words.
+func words(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+	})
+}
+
+// ngrams returns the word n-grams of text from one word up to n, the words of
+// a gram are joined by a space.
+func ngrams(text string, n int) []string {
+	w := words(text)
+	grams := make([]string, 0, len(w)*n)
+	for i := range w {
+		for k := 1; k <= n && i+k <= len(w); k++ {
+			grams = append(grams, strings.Join(w[i:i+k], " "))
+		}
+	}
+	return grams
+}
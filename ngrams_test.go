@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNgrams(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"", 3, "[]"},
+		{" ,. ", 3, "[]"},
+		{"iphone", 3, "[iphone]"},
+		{"iphone 12", 1, "[iphone 12]"},
+		{"iphone 12", 2, "[iphone iphone 12 12]"},
+		// n over the word count gives the grams there are
+		{"iphone 12", 5, "[iphone iphone 12 12]"},
+		{"a b c", 3, "[a a b a b c b b c c]"},
+		// punctuation and spaces of any kind split words
+		{"iphone-12,\tpro", 2, "[iphone iphone 12 12 12 pro pro]"},
+		{"купить  айфон!", 2, "[купить купить айфон айфон]"},
+		// a combining accent stays in its word
+		{"cafe\u0301 noir", 1, "[cafe\u0301 noir]"},
+		{"東京 タワー", 2, "[東京 東京 タワー タワー]"},
+	} {
+		if got := fmt.Sprint(ngrams(c.text, c.n)); got != c.want {
+			t.Errorf("ngrams(%q, %d) = %s, want %s", c.text, c.n, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+const metadataNormalize = "normalize"
+
+// normalizationRule rewrites a query so that spellings of the same query
+// count as one key.
+type normalizationRule struct {
+	name  string
+	apply func(s string) string
+}
+
+// normalizationRules are applied in this order whatever order they are given
+// in, so the same set of rules always gives the same keys.
+var normalizationRules = []normalizationRule{
+	// only %XX is decoded, a + is kept as in "c++"
+	{"url", func(s string) string {
+		if decoded, err := url.PathUnescape(s); err == nil {
+			return decoded
+		}
+		return s
+	}},
+	{"nfkc", norm.NFKC.String},
+	{"lower", func(s string) string {
+		return cases.Fold().String(s)
+	}},
+	{"punct", func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) {
+				return -1
+			}
+			return r
+		}, s)
+	}},
+	{"space", func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}},
+	{"trim", strings.TrimSpace},
+}
+
+// normalizer applies the chosen rules to queries.
+type normalizer struct {
+	rules []normalizationRule
+}
+
+// parseNormalization parses a comma separated list of rules, an empty list
+// leaves queries as they are.
+func parseNormalization(spec string) (*normalizer, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	chosen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		known := false
+		for _, r := range normalizationRules {
+			known = known || r.name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown normalization %q", name)
+		}
+		chosen[name] = true
+	}
+	n := &normalizer{}
+	for _, r := range normalizationRules {
+		if chosen[r.name] {
+			n.rules = append(n.rules, r)
+		}
+	}
+	return n, nil
+}
+
+func (n *normalizer) normalize(s string) string {
+	for _, r := range n.rules {
+		s = r.apply(s)
+	}
+	return s
+}
+
+// String returns the rules in the order they are applied, it is recorded in
+// the DB.
+func (n *normalizer) String() string {
+	if n == nil {
+		return ""
+	}
+	names := make([]string, len(n.rules))
+	for i, r := range n.rules {
+		names[i] = r.name
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	n, err := parseNormalization("trim,space,punct,lower,nfkc,url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ in, want string }{
+		{"iPhone%2012", "iphone 12"},
+		{" IPHONE  12 ", "iphone 12"},
+		{"c++ tutorial", "c++ tutorial"},
+		{"c%2B%2B", "c++"},
+		{"100%", "100"},
+		{"ＡＢＣ", "abc"},
+		{"Straße", "strasse"},
+		{"?!", ""},
+	} {
+		if got := n.normalize(c.in); got != c.want {
+			t.Errorf("normalize(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+	if _, err := parseNormalization("lower,stem"); err == nil {
+		t.Error("unknown rule accepted")
+	}
+}
+
+func TestParseSkipsEmptyNormalized(t *testing.T) {
+	n, err := parseNormalization("punct,trim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	qr := &QueryReader{columns: []string{columnQuery}, format: &keyFormat{normalize: n}}
+	queries, err := qr.parse(" ... ")
+	if err != nil || len(queries) != 0 {
+		t.Errorf("parse of punctuation = %v, %v, want no queries", queries, err)
+	}
+	queries, err = qr.parse("iphone!")
+	if err != nil || len(queries) != 1 || queries[0].key != "iphone" {
+		t.Errorf("parse(iphone!) = %v, %v", queries, err)
+	}
+}
@@ -2,38 +2,208 @@ package main
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 )
 
+const (
+	columnQuery  = "query"
+	columnWeight = "weight"
+	columnUser   = "user"
+	columnTime   = "time"
+)
+
+// querySender is implemented by QueryWorker and ApproxWorker.
+type querySender interface {
+	// Send passes the queries of an input line, offset is the input position
+	// after the line.
+	Send(queries []query, offset int64)
+	Close()
+}
+
 type QueryReader struct {
-	file   *os.File
-	worker *QueryWorker
+	file    *os.File
+	worker  querySender
+	columns []string
+	// format makes the key of the query, dimension and time columns
+	format *keyFormat
+	// dimensions maps columns to dimension indexes, -1 for other columns
+	dimensions []int
+	// offset is the input position Run starts at
+	offset int64
 }
 
 type query struct {
 	key  string
 	vale uint64
+	// user is a user or session id for counting distinct users of the key
+	user string
 }
 
-func NewQueryReader(inPath string, worker *QueryWorker) (*QueryReader, error) {
+// parseColumns parses a comma separated list of tab separated input columns.
+// Every dimension must be one of them.
+func parseColumns(spec string, dimensions []string) ([]string, error) {
+	columns := strings.Split(spec, ",")
+	seen := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		switch c {
+		case columnQuery, columnWeight, columnUser, columnTime:
+		default:
+			if indexOf(dimensions, c) < 0 {
+				return nil, fmt.Errorf("unknown input column %q", c)
+			}
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("input column %q is given twice", c)
+		}
+		seen[c] = true
+	}
+	if !seen[columnQuery] {
+		return nil, fmt.Errorf("input columns must contain %q", columnQuery)
+	}
+	for _, d := range dimensions {
+		if !seen[d] {
+			return nil, fmt.Errorf("dimension %q is not an input column", d)
+		}
+	}
+	return columns, nil
+}
+
+func hasColumn(columns []string, name string) bool {
+	return indexOf(columns, name) >= 0
+}
+
+// NewQueryReader reads the columns of the input file into queries. A nil
+// format takes the query column as the key.
+func NewQueryReader(inPath string, columns []string, format *keyFormat, worker querySender) (*QueryReader, error) {
+	if hasColumn(columns, columnTime) && (format == nil || format.bucket == nil) {
+		return nil, fmt.Errorf("input column %q needs a bucket size", columnTime)
+	}
+	dimensions := make([]int, len(columns))
+	for i, c := range columns {
+		dimensions[i] = -1
+		if format != nil {
+			dimensions[i] = format.dimension(c)
+		}
+	}
 	file, err := os.Open(inPath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &QueryReader{file: file, worker: worker}, nil
+	return &QueryReader{file: file, worker: worker, columns: columns, format: format, dimensions: dimensions}, nil
 }
 
 func (qr *QueryReader) Close() error {
 	return qr.file.Close()
 }
 
+// StartAt makes Run start at offset, the start of a line recorded by a
+// checkpoint.
+func (qr *QueryReader) StartAt(offset int64) error {
+	if _, err := qr.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	qr.offset = offset
+	return nil
+}
+
+// parse splits a line into columns and returns a query for every key made
+// of it. A line of a single query column is taken as is, tabs included.
+func (qr *QueryReader) parse(line string) ([]query, error) {
+	q := query{key: line, vale: 1}
+	var label string
+	var values []string
+	if len(qr.columns) > 1 {
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(qr.columns) {
+			return nil, fmt.Errorf("expected %d columns, got %d", len(qr.columns), len(fields))
+		}
+		if qr.format != nil && len(qr.format.dimensions) > 0 {
+			values = make([]string, len(qr.format.dimensions))
+		}
+		for i, c := range qr.columns {
+			switch c {
+			case columnQuery:
+				q.key = fields[i]
+			case columnWeight:
+				weight, err := strconv.ParseUint(fields[i], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("bad weight: %v", err)
+				}
+				q.vale = weight
+			case columnUser:
+				q.user = fields[i]
+			case columnTime:
+				t, err := parseTimestamp(fields[i])
+				if err != nil {
+					return nil, err
+				}
+				label = qr.format.bucket.label(t)
+			default:
+				if d := qr.dimensions[i]; d >= 0 {
+					values[d] = fields[i]
+				}
+			}
+		}
+	}
+
+	if qr.format != nil && qr.format.normalize != nil {
+		// a query of only punctuation or spaces is not counted
+		if q.key = qr.format.normalize.normalize(q.key); q.key == "" {
+			return nil, nil
+		}
+	}
+	texts := []string{q.key}
+	if qr.format != nil && qr.format.ngrams > 0 {
+		texts = ngrams(q.key, qr.format.ngrams)
+	}
+	queries := make([]query, 0, len(texts))
+	for _, text := range texts {
+		q.key = text
+		if qr.format.composite() {
+			key, err := qr.format.key(text, values, label)
+			if err != nil {
+				return nil, err
+			}
+			q.key = key
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
 func (qr *QueryReader) Run() error {
+	defer qr.worker.Close()
+
+	offset := qr.offset
 	scanner := bufio.NewScanner(qr.file)
-	for scanner.Scan() {
-		qr.worker.Send(scanner.Text())
+	// lines are counted in bytes with their ends
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		offset += int64(advance)
+		return advance, token, err
+	})
+	for line := 1; scanner.Scan(); line++ {
+		queries, err := qr.parse(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		sent := queries[:0]
+		for _, q := range queries {
+			if q.vale != 0 {
+				sent = append(sent, q)
+			}
+		}
+		qr.worker.Send(sent, offset)
 	}
-	qr.worker.Close()
-
-	return scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// the whole input is sent, with no lines after the start too
+	qr.worker.Send(nil, offset)
+	return nil
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sentQueries collects what QueryReader sends.
+type sentQueries struct {
+	queries []query
+	offset  int64
+}
+
+func (s *sentQueries) Send(queries []query, offset int64) {
+	s.queries = append(s.queries, queries...)
+	s.offset = offset
+}
+
+func (s *sentQueries) Close() {}
+
+func TestParseWeight(t *testing.T) {
+	qr := &QueryReader{columns: []string{columnQuery, columnWeight}}
+	for _, c := range []struct {
+		line string
+		want uint64
+		err  string
+	}{
+		{"iphone\t1", 1, ""},
+		{"iphone\t250", 250, ""},
+		{"iphone\t0", 0, ""},
+		{"iphone\t18446744073709551615", 18446744073709551615, ""},
+		{"iphone\t18446744073709551616", 0, "bad weight"},
+		{"iphone\t-1", 0, "bad weight"},
+		{"iphone\tten", 0, "bad weight"},
+		{"iphone\t1.5", 0, "bad weight"},
+		{"iphone\t", 0, "bad weight"},
+		{"iphone", 0, "expected 2 columns, got 1"},
+		{"iphone\t1\t2", 0, "expected 2 columns, got 3"},
+	} {
+		queries, err := qr.parse(c.line)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("parse(%q) error %v, want %q", c.line, err, c.err)
+			}
+			continue
+		}
+		if err != nil || len(queries) != 1 || queries[0].key != "iphone" || queries[0].vale != c.want {
+			t.Errorf("parse(%q) = %v, %v, want weight %d", c.line, queries, err, c.want)
+		}
+	}
+}
+
+func TestRunSkipsZeroWeight(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input")
+	input := "iphone\t3\nsamsung\t0\nnokia\t1\n"
+	if err := os.WriteFile(path, []byte(input), 0666); err != nil {
+		t.Fatal(err)
+	}
+	sent := &sentQueries{}
+	qr, err := NewQueryReader(path, []string{columnQuery, columnWeight}, nil, sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qr.Close()
+	if err := qr.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(sent.queries); got != "[{iphone 3 } {nokia 1 }]" {
+		t.Errorf("sent %s", got)
+	}
+	if sent.offset != int64(len(input)) {
+		t.Errorf("offset %d, want %d", sent.offset, len(input))
+	}
+
+	// a bad weight stops the run at its line
+	if err := os.WriteFile(path, []byte("iphone\t3\nsamsung\t-2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	qr, err = NewQueryReader(path, []string{columnQuery, columnWeight}, nil, &sentQueries{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qr.Close()
+	if err := qr.Run(); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Run error %v, want one at line 2", err)
+	}
+}
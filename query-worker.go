@@ -1,81 +1,299 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"io"
 	"log"
 	"os"
 	"query-counter/btree"
 	"query-counter/lru"
+	"query-counter/sketch"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxHeld caps the held counter writes when checkpoints are only made every
+// interval, past it a checkpoint is made early.
+const maxHeld = 1 << 20
+
+// counterCache is implemented by lru.LRU and lru.ShardedLRU.
+type counterCache interface {
+	OnEvict(f func(key string, value uint64))
+	PushOrIncrement(key string, value uint64)
+	Get(key string) (uint64, bool)
+	Range(f func(key string, value uint64))
+	Flush() []lru.Entry
+	Dirty() int
+	Stats() lru.Stats
+	SaveTo(w io.Writer) error
+	LoadFrom(r io.Reader) error
+}
+
+// Writes and requests handled by ResultProcessing.
+type (
+	// counterWrite adds value to the count of key.
+	counterWrite struct {
+		key   string
+		value uint64
+	}
+	// usersWrite merges a sketch of users into the stored sketch of key.
+	usersWrite struct {
+		key   string
+		users *sketch.HyperLogLog
+	}
+	// checkpoint asks to write the changes so far to disk and to record the
+	// input offset they cover.
+	checkpoint struct {
+		offset int64
+	}
 )
 
 type QueryWorker struct {
-	db       *btree.BTree
-	cache    *lru.LRU
-	poolSize int
-	wg       *sync.WaitGroup
-	workers  chan string
-	results  chan query
+	db         *btree.BTree
+	cache      counterCache
+	poolSize   int
+	wg         *sync.WaitGroup
+	workers    chan query
+	results    chan interface{}
+	done       chan struct{}
+	dirtyLimit int
+	flushNow   chan struct{}
+	stopFlush  chan struct{}
+	flushDone  chan struct{}
+	// gate stops Send while a flush waits for the sent queries to be cached,
+	// inFlight are the queries sent and not cached yet and offset is the
+	// input position after the last line sent
+	gate     sync.RWMutex
+	inFlight sync.WaitGroup
+	offset   int64
+	// held are the counter writes since the last checkpoint, with periodic
+	// flushes they are written with the next one, so the DB only holds the
+	// counts of the input before its recorded offset. heldLen is their number
+	// for the dirty limit, past heldLimit a checkpoint is made.
+	held      map[string]uint64
+	heldLen   int64
+	heldLimit int
+	// snapshot is the path of the cache snapshot, snapshotLoaded tells that
+	// the DB misses the counters loaded from it and snapshotID is the id of
+	// the snapshot saved by Wait
+	snapshot       string
+	snapshotLoaded bool
+	snapshotID     string
+	// loadEvicted are the counters evicted while the snapshot was loaded
+	loadEvicted []counterWrite
+	store       *distinctStore
+	users       *lru.Cache[string, *sketch.HyperLogLog]
+	splitKeys   bool
+	// writes of keys too long for the DB, they are left out of the counts
+	skippedWrites int
+	skippedHits   uint64
 }
 
-func NewQueryWorker(db *btree.BTree, poolSize int, cache *lru.LRU) (*QueryWorker, error) {
-	workers := make(chan string, 100)
-	results := make(chan query, 100)
+func NewQueryWorker(db *btree.BTree, poolSize int, cache counterCache) (*QueryWorker, error) {
+	workers := make(chan query, 100)
+	results := make(chan interface{}, 100)
 	var wg sync.WaitGroup
 
-	return &QueryWorker{
+	qw := &QueryWorker{
 		db:       db,
 		poolSize: poolSize,
 		cache:    cache,
 		workers:  workers,
 		results:  results,
+		done:     make(chan struct{}),
 		wg:       &wg,
-	}, nil
+	}
+	cache.OnEvict(qw.evict)
+	return qw, nil
 }
 
-func (qw *QueryWorker) worker(jobs <-chan string, results chan<- query) {
+func (qw *QueryWorker) evict(key string, value uint64) {
+	// flushed entries stay cached with nothing left to write
+	if value != 0 {
+		qw.results <- counterWrite{key: key, value: value}
+	}
+}
+
+func (qw *QueryWorker) worker(jobs <-chan query) {
 	defer qw.wg.Done()
 	for j := range jobs {
-		oldValue, hasOld := qw.cache.PushOrIncrement(j, 1)
-		if hasOld {
-			results <- query{key: j, vale: oldValue}
+		qw.cache.PushOrIncrement(j.key, j.vale)
+		if qw.users != nil && j.user != "" {
+			qw.addUser(j.key, j.user)
+		}
+		qw.inFlight.Done()
+		if qw.dirtyLimit > 0 && qw.cache.Dirty()+int(atomic.LoadInt64(&qw.heldLen)) >= qw.dirtyLimit {
+			qw.requestFlush()
 		}
 	}
 }
 
+// requestFlush wakes the flusher unless a flush is already requested.
+func (qw *QueryWorker) requestFlush() {
+	select {
+	case qw.flushNow <- struct{}{}:
+	default:
+	}
+}
+
 func (qw *QueryWorker) InitWorkers() {
 	qw.wg.Add(qw.poolSize)
 	for w := 1; w <= qw.poolSize; w++ {
-		go qw.worker(qw.workers, qw.results)
+		go qw.worker(qw.workers)
+	}
+}
+
+// InitFlusher turns the cache into a write-back buffer: changed counters are
+// written to the DB every interval or once dirtyLimit keys are changed,
+// together with the input offset they cover, so a crash loses at most that
+// much and the run can be resumed from the offset. Zero interval or limit
+// disables the trigger, the evicted counters held until the next write are
+// capped by dirtyLimit or maxHeld. It must be called before InitWorkers.
+func (qw *QueryWorker) InitFlusher(interval time.Duration, dirtyLimit int) {
+	if interval <= 0 && dirtyLimit <= 0 {
+		return
 	}
+	qw.dirtyLimit = dirtyLimit
+	qw.flushNow = make(chan struct{}, 1)
+	qw.stopFlush = make(chan struct{})
+	qw.flushDone = make(chan struct{})
+	qw.held = make(map[string]uint64)
+	qw.heldLimit = maxHeld
+	if dirtyLimit > 0 {
+		qw.heldLimit = dirtyLimit
+	}
+
+	go func() {
+		defer close(qw.flushDone)
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+			case <-qw.flushNow:
+			case <-qw.stopFlush:
+				return
+			}
+			qw.flush()
+		}
+	}()
 }
 
-func (qw *QueryWorker) Send(query string) {
-	qw.workers <- query
+// flush stops Send until the lines sent so far are cached and writes them
+// back up to the offset after the last one.
+func (qw *QueryWorker) flush() {
+	qw.gate.Lock()
+	defer qw.gate.Unlock()
+
+	qw.inFlight.Wait()
+	qw.writeBack(true)
 }
 
-func (qw *QueryWorker) writeToDB(key string, value uint64) error {
-	val, ok, err := qw.db.Get(key)
+// writeBack hands the changed counters and the cached sketches to
+// ResultProcessing followed by a checkpoint. Merging a sketch twice does not
+// change the result, with clone copies are written while the cache keeps
+// the originals.
+func (qw *QueryWorker) writeBack(clone bool) {
+	// the counters of a saved snapshot stay out of the DB
+	if qw.snapshotID == "" {
+		for _, e := range qw.cache.Flush() {
+			qw.results <- counterWrite{key: e.Key, value: e.Value}
+		}
+	}
+	if qw.users != nil {
+		for _, w := range qw.userSketches(clone) {
+			qw.results <- w
+		}
+	}
+	qw.results <- checkpoint{offset: qw.offset}
+}
+
+// Send passes the queries of an input line to the workers.
+func (qw *QueryWorker) Send(queries []query, offset int64) {
+	qw.gate.RLock()
+	defer qw.gate.RUnlock()
+
+	qw.inFlight.Add(len(queries))
+	for _, q := range queries {
+		qw.workers <- q
+	}
+	qw.offset = offset
+}
+
+// addCount adds value to the count of key in db.
+func addCount(db *btree.BTree, key string, value uint64) error {
+	val, ok, err := db.Get(key)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	if !ok {
-		if err := qw.db.Insert(btree.NewPairs(key, value)); err != nil {
-			return err
+		return db.Insert(btree.NewPairs(key, value))
+	}
+	_, err = db.Update(key, val+value)
+	return err
+}
+
+func (qw *QueryWorker) writeToDB(key string, value uint64) error {
+	err := addCount(qw.db, key, value)
+	if errors.Is(err, btree.ErrKeyTooLong) {
+		if qw.skippedWrites == 0 {
+			log.Printf("Skipping keys longer than %d bytes, e.g. %q", qw.db.MaxKeyLength(), key)
 		}
+		qw.skippedWrites++
+		qw.skippedHits += value
 		return nil
 	}
-	if _, err = qw.db.Update(key, val+value); err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
+// ResultProcessing is the only writer of the DB, it returns once Wait has
+// flushed the cache.
 func (qw *QueryWorker) ResultProcessing() {
-	for {
-		q := <-qw.results
-		if err := qw.writeToDB(q.key, q.vale); err != nil {
-			log.Fatal(err)
+	defer close(qw.done)
+	for _, w := range qw.loadEvicted {
+		qw.process(w)
+	}
+	qw.loadEvicted = nil
+	for result := range qw.results {
+		qw.process(result)
+	}
+}
+
+func (qw *QueryWorker) process(result interface{}) {
+	var err error
+	switch r := result.(type) {
+	case counterWrite:
+		if qw.held != nil {
+			qw.held[r.key] += r.value
+			atomic.StoreInt64(&qw.heldLen, int64(len(qw.held)))
+			if len(qw.held) >= qw.heldLimit {
+				qw.requestFlush()
+			}
+			return
+		}
+		if qw.snapshotLoaded {
+			err = qw.writeFirstLoaded(r.key, r.value)
+		} else {
+			err = qw.writeToDB(r.key, r.value)
+		}
+	case usersWrite:
+		// the count of a key too long is skipped by writeToDB
+		if err = qw.store.Merge(r.key, r.users); errors.Is(err, btree.ErrKeyTooLong) {
+			err = nil
 		}
+	case checkpoint:
+		err = qw.checkpoint(r.offset)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
@@ -83,15 +301,33 @@ func (qw *QueryWorker) Close() {
 	close(qw.workers)
 }
 
+// Wait writes the counts left in the cache to the DB or, with a cache
+// snapshot, saves the cache to it.
 func (qw *QueryWorker) Wait() {
 	qw.wg.Wait()
-	qw.cache.Range(func(key string, value uint64) {
-		if err := qw.writeToDB(key, value); err != nil {
+	if qw.stopFlush != nil {
+		close(qw.stopFlush)
+		<-qw.flushDone
+	}
+	if qw.snapshot != "" {
+		if err := qw.saveSnapshot(); err != nil {
 			log.Fatal(err)
 		}
-	})
+	}
+	qw.writeBack(false)
+	close(qw.results)
+	<-qw.done
 }
 
+// Skipped returns the number of DB writes left out as their keys are too long
+// and the hits they carried. It is valid after Wait.
+func (qw *QueryWorker) Skipped() (int, uint64) {
+	return qw.skippedWrites, qw.skippedHits
+}
+
+// ExportToFile writes the counts to path. The counters saved to a cache
+// snapshot are added in a DB batch that is never committed, so the DB file
+// is left without them.
 func (qw *QueryWorker) ExportToFile(path string) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -99,5 +335,60 @@ func (qw *QueryWorker) ExportToFile(path string) error {
 	}
 	defer file.Close()
 
-	return qw.db.Export(file)
+	if qw.snapshotID != "" {
+		if err := qw.db.Begin(); err != nil {
+			return err
+		}
+		var cached []lru.Entry
+		qw.cache.Range(func(key string, value uint64) {
+			if value != 0 {
+				cached = append(cached, lru.Entry{Key: key, Value: value})
+			}
+		})
+		for _, e := range cached {
+			if err := qw.writeToDB(e.Key, e.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if qw.store == nil && !qw.splitKeys {
+		return qw.db.Export(file)
+	}
+	return qw.exportSorted(file)
+}
+
+// SplitKeys makes the export write the fields of composite keys as separate
+// columns.
+func (qw *QueryWorker) SplitKeys() {
+	qw.splitKeys = true
+}
+
+// exportSorted writes the key fields, count and distinct users when they are
+// counted in key order.
+func (qw *QueryWorker) exportSorted(file *os.File) error {
+	w := bufio.NewWriter(file)
+	err := qw.db.Ascend(func(key string, value uint64) error {
+		if qw.splitKeys {
+			w.WriteString(strings.ReplaceAll(key, keySeparator, "\t"))
+		} else {
+			w.WriteString(key)
+		}
+		w.WriteByte('\t')
+		w.WriteString(strconv.FormatUint(value, 10))
+		if qw.store != nil {
+			users, err := qw.store.Estimate(key)
+			if err != nil {
+				return err
+			}
+			w.WriteByte('\t')
+			w.WriteString(strconv.FormatUint(users, 10))
+		}
+		_, err := w.WriteString("\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
 }
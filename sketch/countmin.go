@@ -0,0 +1,79 @@
+package sketch
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+)
+
+// CountMin is a Count-Min Sketch: an estimate never undercounts and with
+// probability 1-delta overcounts by at most epsilon times the total of all
+// counts. Add and Estimate are safe for concurrent use.
+type CountMin struct {
+	width    uint64
+	depth    int
+	counters []uint64
+	total    uint64
+}
+
+func NewCountMin(epsilon float64, delta float64) (*CountMin, error) {
+	if epsilon <= 0 || epsilon >= 1 {
+		return nil, errors.New("count-min epsilon must be in (0, 1)")
+	}
+	if delta <= 0 || delta >= 1 {
+		return nil, errors.New("count-min delta must be in (0, 1)")
+	}
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	return &CountMin{
+		width:    width,
+		depth:    depth,
+		counters: make([]uint64, width*uint64(depth)),
+	}, nil
+}
+
+// hash returns two FNV-1a based hashes, row i uses h1 + i*h2.
+func hash(key string) (uint64, uint64) {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h, (h>>33 | h<<31) | 1
+}
+
+// Add counts delta for key and returns the new estimate of its count.
+func (c *CountMin) Add(key string, delta uint64) uint64 {
+	atomic.AddUint64(&c.total, delta)
+	h1, h2 := hash(key)
+	estimate := uint64(math.MaxUint64)
+	for i := 0; i < c.depth; i++ {
+		index := uint64(i)*c.width + (h1+uint64(i)*h2)%c.width
+		if v := atomic.AddUint64(&c.counters[index], delta); v < estimate {
+			estimate = v
+		}
+	}
+	return estimate
+}
+
+func (c *CountMin) Estimate(key string) uint64 {
+	h1, h2 := hash(key)
+	estimate := uint64(math.MaxUint64)
+	for i := 0; i < c.depth; i++ {
+		index := uint64(i)*c.width + (h1+uint64(i)*h2)%c.width
+		if v := atomic.LoadUint64(&c.counters[index]); v < estimate {
+			estimate = v
+		}
+	}
+	return estimate
+}
+
+// Total returns the sum of all counts added.
+func (c *CountMin) Total() uint64 {
+	return atomic.LoadUint64(&c.total)
+}
+
+// MemorySize returns the size of the counters in bytes.
+func (c *CountMin) MemorySize() int {
+	return len(c.counters) * 8
+}
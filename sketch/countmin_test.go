@@ -0,0 +1,75 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountMinBounds(t *testing.T) {
+	const epsilon, delta = 0.01, 0.01
+	cms, err := NewCountMin(epsilon, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("query %d", i%1000)
+		delta := uint64(i%7 + 1)
+		counts[key] += delta
+		if estimate := cms.Add(key, delta); estimate < counts[key] {
+			t.Fatalf("Add(%q) = %d, below the count %d", key, estimate, counts[key])
+		}
+	}
+
+	bound := uint64(epsilon * float64(cms.Total()))
+	over := 0
+	for key, count := range counts {
+		estimate := cms.Estimate(key)
+		if estimate < count {
+			t.Errorf("Estimate(%q) = %d, below the count %d", key, estimate, count)
+		}
+		if estimate > count+bound {
+			over++
+		}
+	}
+	// the bound holds for a key with probability 1-delta
+	if limit := int(2 * delta * float64(len(counts))); over > limit {
+		t.Errorf("%d of %d estimates over the bound, want at most %d", over, len(counts), limit)
+	}
+	if cms.Estimate("never added") > bound {
+		t.Errorf("estimate of a key never added is over the bound %d", bound)
+	}
+}
+
+func TestNewCountMinErrors(t *testing.T) {
+	for _, c := range []struct{ epsilon, delta float64 }{
+		{0, 0.01}, {1, 0.01}, {0.01, 0}, {0.01, 1}, {-1, 0.5},
+	} {
+		if _, err := NewCountMin(c.epsilon, c.delta); err == nil {
+			t.Errorf("NewCountMin(%v, %v) succeeded", c.epsilon, c.delta)
+		}
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	for _, k := range []int{0, -1} {
+		if _, err := NewHeavyHitters(k); err == nil {
+			t.Errorf("NewHeavyHitters(%d) succeeded", k)
+		}
+	}
+
+	hh, err := NewHeavyHitters(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hh.Offer("a", 5)
+	hh.Offer("b", 3)
+	hh.Offer("c", 1)
+	hh.Offer("c", 4)
+	// an offer that came late does not lower the estimate
+	hh.Offer("a", 2)
+	items := hh.Items()
+	if fmt.Sprint(items) != "[{a 5 0} {c 4 0}]" {
+		t.Errorf("Items() = %v, want a 5 and c 4", items)
+	}
+}
@@ -0,0 +1,103 @@
+package sketch
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Item is a key with its estimated count.
+type Item struct {
+	Key   string
+	Count uint64
+	index int
+}
+
+type itemHeap []*Item
+
+func (h itemHeap) Len() int           { return len(h) }
+func (h itemHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+
+func (h itemHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *itemHeap) Push(x interface{}) {
+	item := x.(*Item)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *itemHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// HeavyHitters keeps the k keys with the highest estimates offered so far.
+// It is safe for concurrent use.
+type HeavyHitters struct {
+	k     int
+	items map[string]*Item
+	heap  itemHeap
+	lock  sync.Mutex
+}
+
+func NewHeavyHitters(k int) (*HeavyHitters, error) {
+	if k <= 0 {
+		return nil, errors.New("heavy hitters k must be positive")
+	}
+	return &HeavyHitters{k: k, items: make(map[string]*Item, k)}, nil
+}
+
+// Offer updates the estimate of key, replacing the key of the lowest estimate
+// when k keys are already kept.
+func (hh *HeavyHitters) Offer(key string, count uint64) {
+	hh.lock.Lock()
+	defer hh.lock.Unlock()
+
+	if item, ok := hh.items[key]; ok {
+		// concurrent offers of the same key may come out of order
+		if count > item.Count {
+			item.Count = count
+			heap.Fix(&hh.heap, item.index)
+		}
+		return
+	}
+	if len(hh.heap) < hh.k {
+		item := &Item{Key: key, Count: count}
+		hh.items[key] = item
+		heap.Push(&hh.heap, item)
+		return
+	}
+	if min := hh.heap[0]; count > min.Count {
+		delete(hh.items, min.Key)
+		min.Key = key
+		min.Count = count
+		hh.items[key] = min
+		heap.Fix(&hh.heap, 0)
+	}
+}
+
+// Items returns the kept keys by descending estimate.
+func (hh *HeavyHitters) Items() []Item {
+	hh.lock.Lock()
+	defer hh.lock.Unlock()
+
+	items := make([]Item, len(hh.heap))
+	for i, item := range hh.heap {
+		items[i] = Item{Key: item.Key, Count: item.Count}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
@@ -0,0 +1,209 @@
+package sketch
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	MinPrecision = 4
+	MaxPrecision = 16
+
+	hllSparse = 0
+	hllDense  = 1
+)
+
+// HyperLogLog estimates the number of distinct values added to it in 2^p
+// one byte registers, the standard error is about 1.04/sqrt(2^p). A sketch of
+// few values keeps only the registers it set. It is not safe for concurrent
+// use.
+type HyperLogLog struct {
+	p         uint8
+	registers []uint8
+	// sparse holds index<<8 | rank of the set registers until it grows past
+	// a share of the dense size
+	sparse []uint32
+}
+
+func NewHyperLogLog(p uint8) (*HyperLogLog, error) {
+	if p < MinPrecision || p > MaxPrecision {
+		return nil, fmt.Errorf("hyperloglog precision must be between %d and %d", MinPrecision, MaxPrecision)
+	}
+	return &HyperLogLog{p: p}, nil
+}
+
+// Hash64 is FNV-1a finished with the murmur3 mixer, HyperLogLog needs all
+// bits of the hash to be well distributed.
+func Hash64(value string) uint64 {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(value); i++ {
+		h ^= uint64(value[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (h *HyperLogLog) Precision() uint8 {
+	return h.p
+}
+
+func (h *HyperLogLog) Add(value string) {
+	hash := Hash64(value)
+	index := uint32(hash >> (64 - h.p))
+	rank := uint8(bits.LeadingZeros64(hash<<h.p|1<<(h.p-1)) + 1)
+	h.set(index, rank)
+}
+
+func (h *HyperLogLog) set(index uint32, rank uint8) {
+	if h.registers != nil {
+		if rank > h.registers[index] {
+			h.registers[index] = rank
+		}
+		return
+	}
+	for i, r := range h.sparse {
+		if r>>8 == index {
+			if rank > uint8(r) {
+				h.sparse[i] = index<<8 | uint32(rank)
+			}
+			return
+		}
+	}
+	h.sparse = append(h.sparse, index<<8|uint32(rank))
+	if len(h.sparse)*8 > 1<<h.p {
+		h.densify()
+	}
+}
+
+func (h *HyperLogLog) densify() {
+	h.registers = make([]uint8, 1<<h.p)
+	for _, r := range h.sparse {
+		h.registers[r>>8] = uint8(r)
+	}
+	h.sparse = nil
+}
+
+// Merge adds all values of other to h.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if other.p != h.p {
+		return errors.New("hyperloglog precisions do not match")
+	}
+	if other.registers == nil {
+		for _, r := range other.sparse {
+			h.set(r>>8, uint8(r))
+		}
+		return nil
+	}
+	if h.registers == nil {
+		h.densify()
+	}
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+	return nil
+}
+
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	clone := &HyperLogLog{p: h.p}
+	if h.registers != nil {
+		clone.registers = append([]uint8(nil), h.registers...)
+	} else {
+		clone.sparse = append([]uint32(nil), h.sparse...)
+	}
+	return clone
+}
+
+// Estimate returns the estimated number of distinct values. Small
+// cardinalities are estimated by linear counting of empty registers.
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(uint32(1) << h.p)
+	if h.registers == nil {
+		return uint64(math.Round(m * math.Log(m/(m-float64(len(h.sparse))))))
+	}
+	sum, zeros := 0.0, 0
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+func alpha(m float64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/m)
+}
+
+// Bytes encodes the sketch as precision, format and the registers, either
+// all of them or the set ones as index and rank.
+func (h *HyperLogLog) Bytes() []byte {
+	if h.registers != nil {
+		b := make([]byte, 2, 2+len(h.registers))
+		b[0], b[1] = h.p, hllDense
+		return append(b, h.registers...)
+	}
+	b := make([]byte, 2, 2+3*len(h.sparse))
+	b[0], b[1] = h.p, hllSparse
+	for _, r := range h.sparse {
+		b = append(b, byte(r>>16), byte(r>>8), byte(r))
+	}
+	return b
+}
+
+func HyperLogLogFromBytes(b []byte) (*HyperLogLog, error) {
+	if len(b) < 2 {
+		return nil, errors.New("hyperloglog data is truncated")
+	}
+	h, err := NewHyperLogLog(b[0])
+	if err != nil {
+		return nil, err
+	}
+	data := b[2:]
+	switch b[1] {
+	case hllDense:
+		if len(data) != 1<<h.p {
+			return nil, errors.New("hyperloglog data is truncated")
+		}
+		h.registers = append([]uint8(nil), data...)
+	case hllSparse:
+		if len(data)%3 != 0 {
+			return nil, errors.New("hyperloglog data is truncated")
+		}
+		h.sparse = make([]uint32, 0, len(data)/3)
+		for i := 0; i < len(data); i += 3 {
+			index := uint32(data[i])<<8 | uint32(data[i+1])
+			rank := data[i+2]
+			if index >= 1<<h.p {
+				return nil, fmt.Errorf("hyperloglog register %d is out of range", index)
+			}
+			if rank == 0 || rank > 65-h.p {
+				return nil, fmt.Errorf("hyperloglog register %d has a bad rank %d", index, rank)
+			}
+			h.set(index, rank)
+		}
+	default:
+		return nil, fmt.Errorf("unknown hyperloglog format %d", b[1])
+	}
+	return h, nil
+}
@@ -0,0 +1,90 @@
+package sketch
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func newTestHLL(t *testing.T, p uint8, from, to int) *HyperLogLog {
+	h, err := NewHyperLogLog(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := from; i < to; i++ {
+		h.Add(fmt.Sprintf("user %d", i))
+	}
+	return h
+}
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, p := range []uint8{MinPrecision, 10, 14} {
+		// few values stay sparse, many make the registers dense
+		for _, n := range []int{1, 10, 100, 10000, 100000} {
+			h := newTestHLL(t, p, 0, n)
+			// three standard errors, at least two values off for small n
+			tolerance := math.Max(3*1.04/math.Sqrt(float64(uint32(1)<<p))*float64(n), 2)
+			if got := float64(h.Estimate()); math.Abs(got-float64(n)) > tolerance {
+				t.Errorf("p=%d: estimate %v of %d distinct values", p, got, n)
+			}
+			// values added again do not count
+			again := newTestHLL(t, p, 0, n)
+			for i := 0; i < n; i++ {
+				again.Add(fmt.Sprintf("user %d", i))
+			}
+			if again.Estimate() != h.Estimate() {
+				t.Errorf("p=%d n=%d: repeated values change the estimate", p, n)
+			}
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	for _, n := range []int{50, 20000} {
+		a := newTestHLL(t, 12, 0, n)
+		b := newTestHLL(t, 12, n/2, n+n/2)
+		all := newTestHLL(t, 12, 0, n+n/2)
+		if err := a.Merge(b); err != nil {
+			t.Fatal(err)
+		}
+		if a.Estimate() != all.Estimate() {
+			t.Errorf("n=%d: merged estimate %d, of all values %d", n, a.Estimate(), all.Estimate())
+		}
+		if err := a.Merge(b); err != nil || a.Estimate() != all.Estimate() {
+			t.Errorf("n=%d: merging again changes the estimate: %v", n, err)
+		}
+	}
+	other := newTestHLL(t, 10, 0, 1)
+	if err := newTestHLL(t, 12, 0, 1).Merge(other); err == nil {
+		t.Error("merge of other precision succeeded")
+	}
+}
+
+func TestHyperLogLogBytes(t *testing.T) {
+	for _, n := range []int{0, 5, 5000} {
+		h := newTestHLL(t, 12, 0, n)
+		decoded, err := HyperLogLogFromBytes(h.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Estimate() != h.Estimate() || !bytes.Equal(decoded.Bytes(), h.Bytes()) {
+			t.Errorf("n=%d: decoded sketch differs", n)
+		}
+		before := h.Bytes()
+		clone := h.Clone()
+		for i := 0; i < 100; i++ {
+			clone.Add(fmt.Sprintf("other %d", i))
+		}
+		if !bytes.Equal(h.Bytes(), before) {
+			t.Errorf("n=%d: adding to a clone changes the sketch", n)
+		}
+	}
+	for _, b := range [][]byte{nil, {12}, {12, hllDense, 1, 2}, {12, hllSparse, 1}, {12, 7}, {3, hllSparse},
+		// sparse index past the registers, rank zero and rank past the hash bits
+		{12, hllSparse, 0x10, 0, 1}, {12, hllSparse, 0, 5, 0}, {12, hllSparse, 0, 5, 54}} {
+		if _, err := HyperLogLogFromBytes(b); err == nil {
+			t.Errorf("HyperLogLogFromBytes(%v) succeeded", b)
+		}
+	}
+}
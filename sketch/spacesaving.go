@@ -0,0 +1,112 @@
+package sketch
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Counter is a key monitored by SpaceSaving. Its true count is between
+// Count-Error and Count.
+type Counter struct {
+	Key   string
+	Count uint64
+	Error uint64
+	index int
+}
+
+type counterHeap []*Counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *counterHeap) Push(x interface{}) {
+	c := x.(*Counter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return c
+}
+
+// SpaceSaving monitors at most k keys. A new key takes over the counter of
+// the smallest count and inherits it as its maximum overcount, so every key
+// with a true count above total/k is guaranteed to be monitored. It is safe
+// for concurrent use.
+type SpaceSaving struct {
+	k        int
+	counters map[string]*Counter
+	heap     counterHeap
+	total    uint64
+	lock     sync.Mutex
+}
+
+func NewSpaceSaving(k int) (*SpaceSaving, error) {
+	if k <= 0 {
+		return nil, errors.New("space-saving k must be positive")
+	}
+	return &SpaceSaving{k: k, counters: make(map[string]*Counter, k)}, nil
+}
+
+func (s *SpaceSaving) Add(key string, delta uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.total += delta
+	if c, ok := s.counters[key]; ok {
+		c.Count += delta
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	if len(s.heap) < s.k {
+		c := &Counter{Key: key, Count: delta}
+		s.counters[key] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+	min := s.heap[0]
+	delete(s.counters, min.Key)
+	min.Key = key
+	min.Error = min.Count
+	min.Count += delta
+	s.counters[key] = min
+	heap.Fix(&s.heap, 0)
+}
+
+// Total returns the sum of all counts added.
+func (s *SpaceSaving) Total() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.total
+}
+
+// Counters returns the monitored keys by descending count.
+func (s *SpaceSaving) Counters() []Counter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	counters := make([]Counter, len(s.heap))
+	for i, c := range s.heap {
+		counters[i] = Counter{Key: c.Key, Count: c.Count, Error: c.Error}
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Count != counters[j].Count {
+			return counters[i].Count > counters[j].Count
+		}
+		return counters[i].Key < counters[j].Key
+	})
+	return counters
+}
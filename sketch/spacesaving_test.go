@@ -0,0 +1,58 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSpaceSavingBounds(t *testing.T) {
+	if _, err := NewSpaceSaving(0); err == nil {
+		t.Error("NewSpaceSaving(0) succeeded")
+	}
+
+	const k = 20
+	s, err := NewSpaceSaving(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	add := func(key string, delta uint64) {
+		counts[key] += delta
+		s.Add(key, delta)
+	}
+	// a few heavy keys among many light ones
+	for i := 0; i < 3000; i++ {
+		add(fmt.Sprintf("light %d", i), 1)
+		if i%10 == 0 {
+			add(fmt.Sprintf("heavy %d", i%3), 3)
+		}
+	}
+	if s.Total() != 3900 {
+		t.Errorf("Total() = %d, want 3900", s.Total())
+	}
+
+	counters := s.Counters()
+	if len(counters) != k {
+		t.Fatalf("%d counters, want %d", len(counters), k)
+	}
+	monitored := make(map[string]bool)
+	for i, c := range counters {
+		monitored[c.Key] = true
+		if i > 0 && c.Count > counters[i-1].Count {
+			t.Errorf("counters are not by descending count: %v", counters)
+		}
+		if c.Count < counts[c.Key] || c.Count-c.Error > counts[c.Key] {
+			t.Errorf("%s: count %d error %d, true count %d", c.Key, c.Count, c.Error, counts[c.Key])
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if key := fmt.Sprintf("heavy %d", i); !monitored[key] {
+			t.Errorf("%s of count %d over total/k is not monitored", key, counts[key])
+		}
+	}
+	for key, count := range counts {
+		if count > s.Total()/k && !monitored[key] {
+			t.Errorf("%s of count %d over total/k is not monitored", key, count)
+		}
+	}
+}
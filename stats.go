@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// countStats describes the distribution of per-key counts.
+type countStats struct {
+	// counts are sorted in descending order
+	counts     []uint64
+	total      uint64
+	singletons int
+}
+
+func newCountStats(counts []uint64) *countStats {
+	sort.Slice(counts, func(i, j int) bool { return counts[i] > counts[j] })
+	s := &countStats{counts: counts}
+	for _, c := range counts {
+		s.total += c
+		if c == 1 {
+			s.singletons++
+		}
+	}
+	return s
+}
+
+// percentile returns the count not exceeded by p percent of the keys.
+func (s *countStats) percentile(p float64) uint64 {
+	if len(s.counts) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(s.counts))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(s.counts) {
+		rank = len(s.counts)
+	}
+	return s.counts[len(s.counts)-rank]
+}
+
+// zipf fits count = C / rank^s by least squares of the logarithms and
+// returns s and the coefficient of determination. Only the first limit
+// ranks are taken when limit is positive, the tail of ties flattens the fit.
+func (s *countStats) zipf(limit int) (float64, float64) {
+	n := len(s.counts)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	if n < 2 {
+		return 0, 0
+	}
+	var sx, sy, sxx, sxy, syy float64
+	for i, c := range s.counts[:n] {
+		x, y := math.Log(float64(i+1)), math.Log(float64(c))
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+		syy += y * y
+	}
+	m := float64(n)
+	cov := sxy - sx*sy/m
+	varX := sxx - sx*sx/m
+	varY := syy - sy*sy/m
+	if varX == 0 || varY == 0 {
+		return 0, 0
+	}
+	slope := cov / varX
+	return -slope, cov * cov / (varX * varY)
+}
+
+func (s *countStats) write(w *bufio.Writer, zipfLimit int) {
+	distinct := len(s.counts)
+	fmt.Fprintf(w, "total hits\t%d\n", s.total)
+	fmt.Fprintf(w, "distinct keys\t%d\n", distinct)
+	if distinct == 0 {
+		return
+	}
+	fmt.Fprintf(w, "singletons\t%d\t%.4f\n", s.singletons, float64(s.singletons)/float64(distinct))
+
+	fmt.Fprintf(w, "\npercentiles\n")
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(w, "p%g\t%d\n", p, s.percentile(p))
+	}
+	fmt.Fprintf(w, "max\t%d\n", s.counts[0])
+
+	exponent, r2 := s.zipf(zipfLimit)
+	fmt.Fprintf(w, "\nzipf exponent\t%.4f\tr2 %.4f\n", exponent, r2)
+
+	// buckets of counts from 2^i to 2^(i+1)-1, zero counts are left out
+	// by runStats
+	var keys, hits [65]uint64
+	for _, c := range s.counts {
+		b := bits.Len64(c) - 1
+		keys[b]++
+		hits[b] += c
+	}
+	fmt.Fprintf(w, "\nhistogram\tkeys\tkeys share\thits share\n")
+	for b := range keys {
+		if keys[b] == 0 {
+			continue
+		}
+		low, high := uint64(1)<<b, uint64(1)<<b<<1-1
+		if b == 63 {
+			high = math.MaxUint64
+		}
+		fmt.Fprintf(w, "%d-%d\t%d\t%.4f\t%.4f\n", low, high, keys[b],
+			float64(keys[b])/float64(distinct), float64(hits[b])/float64(s.total))
+	}
+
+	// the hits a cache of the top keys would take
+	fmt.Fprintf(w, "\ntop keys\thits share\n")
+	var covered uint64
+	next := 10
+	for i, c := range s.counts {
+		covered += c
+		if i+1 == next || i+1 == distinct {
+			fmt.Fprintf(w, "%d\t%.4f\n", i+1, float64(covered)/float64(s.total))
+			next *= 10
+		}
+	}
+}
+
+// runStats writes the distribution of per-key counts of a kept DB.
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	dbFlags := addDBFlags(fs)
+	var zipfKeys = fs.Int("zipf-keys", 0, "Fit the Zipf exponent on this many top keys, all when zero")
+	var outputPath = fs.String("output", "", "Result file, standard output when empty")
+	fs.Parse(args)
+
+	db, err := dbFlags.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var counts []uint64
+	err = db.Ascend(func(key string, value uint64) error {
+		if value > 0 {
+			counts = append(counts, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stats := newCountStats(counts)
+	return writeOutput(*outputPath, func(w *bufio.Writer) error {
+		stats.write(w, *zipfKeys)
+		return nil
+	})
+}
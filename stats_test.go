@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	hundred := make([]uint64, 100)
+	for i := range hundred {
+		hundred[i] = uint64(i + 1)
+	}
+	for _, c := range []struct {
+		name   string
+		counts []uint64
+		p      float64
+		want   uint64
+	}{
+		{"none", nil, 50, 0},
+		{"one", []uint64{7}, 0, 7},
+		{"one", []uint64{7}, 50, 7},
+		{"one", []uint64{7}, 99.9, 7},
+		{"two", []uint64{1, 10}, 0, 1},
+		{"two", []uint64{1, 10}, 50, 1},
+		{"two", []uint64{10, 1}, 51, 10},
+		{"two", []uint64{1, 10}, 100, 10},
+		{"n", hundred, 1, 1},
+		{"n", hundred, 50, 50},
+		{"n", hundred, 90, 90},
+		{"n", hundred, 99, 99},
+		{"n", hundred, 99.9, 100},
+		{"n", hundred, 120, 100},
+	} {
+		counts := append([]uint64(nil), c.counts...)
+		if got := newCountStats(counts).percentile(c.p); got != c.want {
+			t.Errorf("%s: p%g = %d, want %d", c.name, c.p, got, c.want)
+		}
+	}
+}
+
+func TestZipf(t *testing.T) {
+	for _, exponent := range []float64{0.8, 1, 2} {
+		counts := make([]uint64, 200)
+		for i := range counts {
+			counts[i] = uint64(math.Round(1e9 / math.Pow(float64(i+1), exponent)))
+		}
+		s, r2 := newCountStats(counts).zipf(0)
+		if math.Abs(s-exponent) > 1e-3 || r2 < 0.9999 {
+			t.Errorf("exponent %v: fit %v, r2 %v", exponent, s, r2)
+		}
+	}
+
+	// the tail of ties is left out with a limit
+	counts := []uint64{1000, 500, 333, 250, 200, 1, 1, 1, 1, 1, 1, 1, 1}
+	if s, _ := newCountStats(counts).zipf(5); math.Abs(s-1) > 0.01 {
+		t.Errorf("fit of the top 5 is %v, want 1", s)
+	}
+	if s, _ := newCountStats(counts).zipf(0); s < 1.5 {
+		t.Errorf("fit with the tail is %v, want it steeper", s)
+	}
+
+	for _, counts := range [][]uint64{nil, {5}, {3, 3, 3}} {
+		if s, r2 := newCountStats(counts).zipf(0); s != 0 || r2 != 0 {
+			t.Errorf("zipf(%v) = %v, %v, want zeros", counts, s, r2)
+		}
+	}
+}
+
+func TestStatsWrite(t *testing.T) {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	newCountStats([]uint64{1, 2, 3, 4, 8, 1}).write(w, 0)
+	w.Flush()
+	for _, line := range []string{
+		"total hits\t19\n",
+		"distinct keys\t6\n",
+		"singletons\t2\t0.3333\n",
+		"max\t8\n",
+		"1-1\t2\t0.3333\t0.1053\n",
+		"2-3\t2\t0.3333\t0.2632\n",
+		"4-7\t1\t0.1667\t0.2105\n",
+		"8-15\t1\t0.1667\t0.4211\n",
+		"\ntop keys\thits share\n6\t1.0000\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("stats have no %q:\n%s", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"query-counter/btree"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// keySeparator joins the fields of a composite DB key, such as a query
+	// and its time bucket.
+	keySeparator = "\x1f"
+
+	metadataBucket = "bucket"
+)
+
+// bucketSize is a granularity of time buckets. Bucket labels sort in time
+// order, so the buckets of a query are stored next to each other.
+type bucketSize struct {
+	name     string
+	layout   string
+	duration time.Duration
+}
+
+var bucketSizes = map[string]*bucketSize{
+	"hour": {name: "hour", layout: "2006-01-02T15", duration: time.Hour},
+	"day":  {name: "day", layout: "2006-01-02", duration: 24 * time.Hour},
+}
+
+func parseBucketSize(name string) (*bucketSize, error) {
+	b, ok := bucketSizes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket %q, use hour or day", name)
+	}
+	return b, nil
+}
+
+// label returns the bucket of t in UTC.
+func (b *bucketSize) label(t time.Time) string {
+	return t.UTC().Format(b.layout)
+}
+
+// start returns the start of the bucket with the label.
+func (b *bucketSize) start(label string) (time.Time, error) {
+	return time.Parse(b.layout, label)
+}
+
+// splitBucketKey returns the query and the bucket label of a DB key.
+func splitBucketKey(key string) (string, string, error) {
+	i := strings.LastIndex(key, keySeparator)
+	if i < 0 {
+		return "", "", fmt.Errorf("key %q has no time bucket", key)
+	}
+	return key[:i], key[i+len(keySeparator):], nil
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"20060102",
+}
+
+// parseTimestamp parses a date and time or unix seconds, the ones without a
+// zone are taken as UTC. Digits are taken as seconds only when they are not a
+// date and have 9 or 10 of them, which covers 1973 to 2286: "20240101" is a
+// day, not a moment of 1970.
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if len(s) == 9 || len(s) == 10 {
+		if seconds, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return time.Unix(int64(seconds), 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad timestamp %q", s)
+}
+
+// bucketRange parses the from and to flags of the bucket commands into
+// bucket labels, an empty flag leaves the range open.
+func bucketRange(bucket *bucketSize, from string, to string) (string, string, error) {
+	var first, last string
+	if from != "" {
+		t, err := parseTimestamp(from)
+		if err != nil {
+			return "", "", err
+		}
+		first = bucket.label(t)
+	}
+	if to != "" {
+		t, err := parseTimestamp(to)
+		if err != nil {
+			return "", "", err
+		}
+		last = bucket.label(t)
+	}
+	return first, last, nil
+}
+
+// openBucketedDB opens a kept DB counted with time buckets.
+func openBucketedDB(flags *dbFlags) (*btree.BTree, *keyFormat, error) {
+	db, err := flags.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	format, err := keyFormatOf(db)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if format.bucket == nil {
+		db.Close()
+		return nil, nil, errors.New("DB is counted without time buckets")
+	}
+	return db, format, nil
+}
+
+// runSeries writes the counts of a query by bucket between from and to
+// inclusive, summed over the dimensions.
+func runSeries(args []string) error {
+	fs := flag.NewFlagSet("series", flag.ExitOnError)
+	dbFlags := addDBFlags(fs)
+	var query = fs.String("query", "", "Query to export counts of")
+	var from = fs.String("from", "", "First time bucket, unix seconds or date and time")
+	var to = fs.String("to", "", "Last time bucket, unix seconds or date and time")
+	var outputPath = fs.String("output", "", "Result file, standard output when empty")
+	fs.Parse(args)
+
+	db, format, err := openBucketedDB(dbFlags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	first, last, err := bucketRange(format.bucket, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	// all keys of the query follow each other, with dimensions the buckets
+	// repeat for every combination of them
+	prefix := *query + keySeparator
+	counts := make(map[string]uint64)
+	err = db.AscendFrom(prefix, func(key string, value uint64) error {
+		if !strings.HasPrefix(key, prefix) {
+			return btree.StopAscend
+		}
+		_, label, err := splitBucketKey(key)
+		if err != nil {
+			return err
+		}
+		if (first == "" || label >= first) && (last == "" || label <= last) {
+			counts[label] += value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	labels := make([]string, 0, len(counts))
+	for label := range counts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	return writeOutput(*outputPath, func(w *bufio.Writer) error {
+		for _, label := range labels {
+			w.WriteString(label)
+			w.WriteByte('\t')
+			w.WriteString(strconv.FormatUint(counts[label], 10))
+			w.WriteByte('\n')
+		}
+		return nil
+	})
+}
+
+// runRange writes the total of every query and its dimensions over the
+// buckets between from and to inclusive.
+func runRange(args []string) error {
+	fs := flag.NewFlagSet("range", flag.ExitOnError)
+	dbFlags := addDBFlags(fs)
+	var from = fs.String("from", "", "First time bucket, unix seconds or date and time")
+	var to = fs.String("to", "", "Last time bucket, unix seconds or date and time")
+	var outputPath = fs.String("output", "", "Result file, standard output when empty")
+	fs.Parse(args)
+
+	db, format, err := openBucketedDB(dbFlags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	first, last, err := bucketRange(format.bucket, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(*outputPath, func(w *bufio.Writer) error {
+		// buckets of a query follow each other, so a total is complete once
+		// the next query starts
+		current, total := "", uint64(0)
+		write := func() {
+			if total > 0 {
+				w.WriteString(strings.ReplaceAll(current, keySeparator, "\t"))
+				w.WriteByte('\t')
+				w.WriteString(strconv.FormatUint(total, 10))
+				w.WriteByte('\n')
+			}
+		}
+		err := db.Ascend(func(key string, value uint64) error {
+			query, label, err := splitBucketKey(key)
+			if err != nil {
+				return err
+			}
+			if query != current {
+				write()
+				current, total = query, 0
+			}
+			if (first == "" || label >= first) && (last == "" || label <= last) {
+				total += value
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		write()
+		return nil
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want time.Time
+	}{
+		{"1700000000", time.Unix(1700000000, 0)},
+		{"999999999", time.Unix(999999999, 0)},
+		{"20240101", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-02 03:04:05", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2024-01-02T03:04:05", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2024-01-02T03:04:05+03:00", time.Date(2024, 1, 2, 0, 4, 5, 0, time.UTC)},
+	} {
+		got, err := parseTimestamp(c.s)
+		if err != nil || !got.Equal(c.want) {
+			t.Errorf("parseTimestamp(%q) = %v, %v, want %v", c.s, got, err, c.want)
+		}
+	}
+	// too short or too long for seconds and not a date
+	for _, s := range []string{"", "12345", "20241301", "12345678901", "-100000000", "1700000000.5", "yesterday"} {
+		if got, err := parseTimestamp(s); err == nil {
+			t.Errorf("parseTimestamp(%q) = %v", s, got)
+		}
+	}
+}
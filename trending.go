@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"flag"
+	"fmt"
+	"math"
+	"query-counter/btree"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trend compares the count of a query in the recent window with the count
+// expected from its history.
+type trend struct {
+	query    string
+	recent   uint64
+	expected float64
+	score    float64
+}
+
+// trends is a min-heap of trends by score.
+type trends []trend
+
+func (t trends) Len() int           { return len(t) }
+func (t trends) Less(i, j int) bool { return t[i].score < t[j].score }
+func (t trends) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+func (t *trends) Push(x interface{}) {
+	*t = append(*t, x.(trend))
+}
+
+func (t *trends) Pop() interface{} {
+	old := *t
+	e := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return e
+}
+
+// add keeps the n trends of the highest scores.
+func (t *trends) add(e trend, n int) {
+	if len(*t) < n {
+		heap.Push(t, e)
+		return
+	}
+	if e.score > (*t)[0].score {
+		(*t)[0] = e
+		heap.Fix(t, 0)
+	}
+}
+
+// trendModel scores queries by their time buckets. The window is the last
+// windowBuckets buckets up to now, the history is the historyBuckets before
+// it. The baseline rate is the average count of the history buckets weighted
+// by exponential decay, so the older buckets count less. No decayed score is
+// kept while counting: the bucket counts are exact, so the decay and the
+// windows are applied when the report is built and may change per report,
+// at the precision of a bucket.
+type trendModel struct {
+	bucket         *bucketSize
+	now            time.Time
+	windowBuckets  int
+	historyBuckets int
+	// weights[i] is the decay weight of the history bucket i buckets before
+	// the window
+	weights     []float64
+	totalWeight float64
+}
+
+func newTrendModel(bucket *bucketSize, now time.Time, window time.Duration, history time.Duration, halfLife time.Duration) (*trendModel, error) {
+	if window <= 0 || history <= 0 || halfLife <= 0 {
+		return nil, fmt.Errorf("window, history and half-life must be positive")
+	}
+	m := &trendModel{
+		bucket:         bucket,
+		now:            now,
+		windowBuckets:  bucketsIn(window, bucket),
+		historyBuckets: bucketsIn(history, bucket),
+	}
+	m.weights = make([]float64, m.historyBuckets)
+	for i := range m.weights {
+		age := float64(i) * float64(bucket.duration) / float64(halfLife)
+		m.weights[i] = math.Exp2(-age)
+		m.totalWeight += m.weights[i]
+	}
+	return m, nil
+}
+
+// bucketsIn returns the number of buckets covering d, at least one.
+func bucketsIn(d time.Duration, bucket *bucketSize) int {
+	n := int((d + bucket.duration - 1) / bucket.duration)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// queryTrend accumulates the buckets of a single query.
+type queryTrend struct {
+	recent   uint64
+	weighted float64
+}
+
+// add counts a bucket of the query that is before now buckets ago.
+func (m *trendModel) add(q *queryTrend, before int, count uint64) {
+	switch {
+	case before < 0:
+	case before < m.windowBuckets:
+		q.recent += count
+	case before < m.windowBuckets+m.historyBuckets:
+		q.weighted += m.weights[before-m.windowBuckets] * float64(count)
+	}
+}
+
+// trend scores a query by the ratio of its recent count to the expected
+// one, both smoothed by one so rare and new queries do not dominate.
+func (m *trendModel) trend(query string, q *queryTrend) trend {
+	expected := q.weighted / m.totalWeight * float64(m.windowBuckets)
+	return trend{
+		query:    query,
+		recent:   q.recent,
+		expected: expected,
+		score:    (float64(q.recent) + 1) / (expected + 1),
+	}
+}
+
+// latestBucket returns the start of the latest bucket in the DB.
+func latestBucket(db *btree.BTree, bucket *bucketSize) (time.Time, error) {
+	var latest string
+	err := db.Ascend(func(key string, value uint64) error {
+		_, label, err := splitBucketKey(key)
+		if err != nil {
+			return err
+		}
+		if label > latest {
+			latest = label
+		}
+		return nil
+	})
+	if err != nil || latest == "" {
+		return time.Time{}, err
+	}
+	return bucket.start(latest)
+}
+
+// runTrending writes the queries, with their dimensions, whose count in the
+// recent window most exceeds the one expected from their decayed history.
+func runTrending(args []string) error {
+	fs := flag.NewFlagSet("trending", flag.ExitOnError)
+	dbFlags := addDBFlags(fs)
+	var now = fs.String("now", "", "End of the recent window, unix seconds or date and time, the latest bucket in DB when empty")
+	var window = fs.Duration("window", 24*time.Hour, "Recent window to look for trends in")
+	var history = fs.Duration("history", 28*24*time.Hour, "History before the window to take the baseline from")
+	var halfLife = fs.Duration("half-life", 7*24*time.Hour, "Half-life of the history weight")
+	var minRecent = fs.Uint64("min-recent", 5, "Skip queries with fewer hits in the recent window")
+	var top = fs.Int("top", 100, "Number of queries to report")
+	var outputPath = fs.String("output", "", "Result file, standard output when empty")
+	fs.Parse(args)
+	if *top <= 0 {
+		return fmt.Errorf("bad top %d", *top)
+	}
+
+	db, format, err := openBucketedDB(dbFlags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	bucket := format.bucket
+
+	var end time.Time
+	if *now != "" {
+		end, err = parseTimestamp(*now)
+	} else {
+		end, err = latestBucket(db, bucket)
+	}
+	if err != nil {
+		return err
+	}
+	end = end.UTC().Truncate(bucket.duration)
+	model, err := newTrendModel(bucket, end, *window, *history, *halfLife)
+	if err != nil {
+		return err
+	}
+
+	best := make(trends, 0, *top)
+	current, q := "", &queryTrend{}
+	done := func() {
+		if current != "" && q.recent >= *minRecent {
+			best.add(model.trend(current, q), *top)
+		}
+	}
+	err = db.Ascend(func(key string, value uint64) error {
+		query, label, err := splitBucketKey(key)
+		if err != nil {
+			return err
+		}
+		if query != current {
+			done()
+			current, q = query, &queryTrend{}
+		}
+		start, err := bucket.start(label)
+		if err != nil {
+			return err
+		}
+		model.add(q, int(end.Sub(start)/bucket.duration), value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	done()
+
+	sort.Slice(best, func(i, j int) bool { return best[i].score > best[j].score })
+	return writeOutput(*outputPath, func(w *bufio.Writer) error {
+		for _, t := range best {
+			w.WriteString(strings.ReplaceAll(t.query, keySeparator, "\t"))
+			w.WriteByte('\t')
+			w.WriteString(strconv.FormatUint(t.recent, 10))
+			w.WriteByte('\t')
+			w.WriteString(strconv.FormatFloat(t.expected, 'f', 2, 64))
+			w.WriteByte('\t')
+			w.WriteString(strconv.FormatFloat(t.score, 'f', 2, 64))
+			w.WriteByte('\n')
+		}
+		return nil
+	})
+}